@@ -5,6 +5,7 @@ go 1.24.2
 require (
 	github.com/eliukblau/pixterm v1.3.2
 	github.com/fatih/color v1.18.0
+	golang.org/x/image v0.20.0
 	golang.org/x/term v0.32.0
 )
 
@@ -13,6 +14,5 @@ require (
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	golang.org/x/image v0.20.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 )
@@ -0,0 +1,121 @@
+// Package farbfeld implements the farbfeld image format of suckless.org:
+// a magic string, the width and height as 32-bit big-endian integers and
+// then every pixel as four 16-bit big-endian RGBA samples, row by row,
+// with straight alpha. Importing this package registers the format with
+// the standard library, so image.Decode understands farbfeld files.
+package farbfeld
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"huh/src/internal/imageutil"
+)
+
+// Magic is the signature every farbfeld file starts with.
+const Magic = "farbfeld"
+
+// farbfeld has no compression, so a file is as large as its image, but
+// Decode allocates the image from the 16-byte header before reading any
+// pixels. maxPixels caps that at 2 GiB of samples. maxSide, the default
+// limit of libpng, refuses degenerate sizes such as 1×268435456 that
+// maxPixels lets through.
+const (
+	maxPixels = 1 << 28
+	maxSide   = 1_000_000
+)
+
+func validSize(w, h uint64) bool {
+	return w > 0 && h > 0 && w <= maxSide && h <= maxSide && w*h <= maxPixels
+}
+
+func readHeader(r io.Reader) (width, height int, err error) {
+	var buf [16]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil || string(buf[:8]) != Magic {
+		return 0, 0, errors.New("farbfeld: invalid header")
+	}
+	w, h := binary.BigEndian.Uint32(buf[8:]), binary.BigEndian.Uint32(buf[12:])
+	if !validSize(uint64(w), uint64(h)) {
+		return 0, 0, fmt.Errorf("farbfeld: invalid image size %dx%d", w, h)
+	}
+	return int(w), int(h), nil
+}
+
+// Decode reads a farbfeld image from r as an *image.NRGBA64.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	w, h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	// The samples are stored exactly as NRGBA64 holds them.
+	for y := 0; y < h; y++ {
+		if _, err := io.ReadFull(br, img.Pix[y*img.Stride:y*img.Stride+w*8]); err != nil {
+			return nil, imageutil.NoEOF(err)
+		}
+	}
+	return img, nil
+}
+
+// DecodeConfig returns the color model and dimensions of a farbfeld image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	w, h, err := readHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBA64Model, Width: w, Height: h}, nil
+}
+
+// Encode writes img to w in farbfeld format. Empty images are refused, as
+// Decode would not read them back.
+func Encode(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if !validSize(uint64(b.Dx()), uint64(b.Dy())) {
+		return fmt.Errorf("farbfeld: invalid image size %dx%d", b.Dx(), b.Dy())
+	}
+	bw := bufio.NewWriter(w)
+	var head [16]byte
+	copy(head[:], Magic)
+	binary.BigEndian.PutUint32(head[8:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(head[12:], uint32(b.Dy()))
+	bw.Write(head[:])
+	row := make([]byte, b.Dx()*8)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		switch src := img.(type) {
+		case *image.NRGBA64:
+			i := src.PixOffset(b.Min.X, y)
+			copy(row, src.Pix[i:i+len(row)])
+		case *image.NRGBA:
+			// Converting through premultiplied colors would lose the color
+			// of nearly transparent pixels.
+			i := src.PixOffset(b.Min.X, y)
+			for j, v := range src.Pix[i : i+b.Dx()*4] {
+				row[2*j], row[2*j+1] = v, v
+			}
+		default:
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				px := row[(x-b.Min.X)*8:]
+				binary.BigEndian.PutUint16(px[0:], c.R)
+				binary.BigEndian.PutUint16(px[2:], c.G)
+				binary.BigEndian.PutUint16(px[4:], c.B)
+				binary.BigEndian.PutUint16(px[6:], c.A)
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func init() {
+	image.RegisterFormat("farbfeld", Magic, Decode, DecodeConfig)
+}
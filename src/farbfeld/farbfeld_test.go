@@ -0,0 +1,122 @@
+package farbfeld
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+	"testing"
+)
+
+func header(w, h uint32) []byte {
+	head := []byte(Magic)
+	head = binary.BigEndian.AppendUint32(head, w)
+	return binary.BigEndian.AppendUint32(head, h)
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := image.Rect(0, 0, 13, 7)
+	n64 := image.NewNRGBA64(r)
+	n8 := image.NewNRGBA(r)
+	rgba := image.NewRGBA(r)
+	gray := image.NewGray16(r)
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 13; x++ {
+			n64.SetNRGBA64(x, y, color.NRGBA64{uint16(x * 5001), uint16(y * 9001), uint16(x*y*77 + 1), uint16(x * 4000)})
+			// Nearly transparent pixels keep their color.
+			n8.SetNRGBA(x, y, color.NRGBA{uint8(x * 19), uint8(y * 31), 200, uint8(x)})
+			rgba.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8(x + y), 255})
+			gray.SetGray16(x, y, color.Gray16{uint16(x*y) * 700})
+		}
+	}
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"NRGBA64", n64},
+		{"NRGBA", n8},
+		{"RGBA", rgba},
+		{"Gray16", gray},
+		{"subimage", n64.SubImage(image.Rect(3, 2, 11, 6))},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tt.img); err != nil {
+			t.Fatalf("%s: Encode: %v", tt.name, err)
+		}
+		b := tt.img.Bounds()
+		if want := 16 + 8*b.Dx()*b.Dy(); buf.Len() != want {
+			t.Errorf("%s: %d bytes, want %d", tt.name, buf.Len(), want)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+		if err != nil || format != "farbfeld" || cfg.Width != b.Dx() || cfg.Height != b.Dy() || cfg.ColorModel != color.NRGBA64Model {
+			t.Errorf("%s: DecodeConfig: %v, %q, %+v", tt.name, err, format, cfg)
+		}
+		got, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", tt.name, err)
+		}
+		if got.Bounds() != b.Sub(b.Min) {
+			t.Fatalf("%s: bounds %v, want %v", tt.name, got.Bounds(), b.Sub(b.Min))
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				var want color.NRGBA64
+				switch src := tt.img.(type) {
+				case *image.NRGBA:
+					c := src.NRGBAAt(x, y)
+					want = color.NRGBA64{uint16(c.R) * 0x101, uint16(c.G) * 0x101, uint16(c.B) * 0x101, uint16(c.A) * 0x101}
+				default:
+					want = color.NRGBA64Model.Convert(tt.img.At(x, y)).(color.NRGBA64)
+				}
+				if c := got.(*image.NRGBA64).NRGBA64At(x-b.Min.X, y-b.Min.Y); c != want {
+					t.Fatalf("%s: pixel (%d, %d) is %v, want %v", tt.name, x, y, c, want)
+				}
+			}
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	valid := append(header(2, 1), make([]byte, 16)...)
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "invalid header"},
+		{"short header", valid[:15], "invalid header"},
+		{"bad magic", append([]byte("farbfelt"), valid[8:]...), "invalid header"},
+		{"zero width", header(0, 0xffffffff), "invalid image size"},
+		{"zero height", header(0xffffffff, 0), "invalid image size"},
+		{"zero size", header(0, 0), "invalid image size"},
+		{"wide", header(maxSide+1, 1), "invalid image size"},
+		{"tall", header(1, maxSide+1), "invalid image size"},
+		{"too many pixels", header(1<<15, 1<<15), "invalid image size"},
+		{"largest product overflows", header(0xffffffff, 0xffffffff), "invalid image size"},
+		{"truncated pixels", valid[:len(valid)-1], io.ErrUnexpectedEOF.Error()},
+		{"no pixels", valid[:16], io.ErrUnexpectedEOF.Error()},
+	}
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Decode: got %v, want %q", tt.name, err, tt.want)
+		}
+		if len(tt.data) == 16 && tt.want != io.ErrUnexpectedEOF.Error() {
+			if _, err := DecodeConfig(bytes.NewReader(tt.data)); err == nil {
+				t.Errorf("%s: DecodeConfig: no error", tt.name)
+			}
+		}
+	}
+	if _, err := Decode(bytes.NewReader(valid)); err != nil {
+		t.Errorf("valid: %v", err)
+	}
+
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 0, 5), image.Rect(0, 0, 5, 0), image.Rect(0, 0, maxSide+1, 1)} {
+		if err := Encode(io.Discard, image.NewNRGBA64(r)); err == nil {
+			t.Errorf("Encode of a %v image: no error", r)
+		}
+	}
+}
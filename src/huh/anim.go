@@ -0,0 +1,387 @@
+package huh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// Animated files hold their first frame like any other file, so decoders
+// that do not know about animation show it as a still image. The rest is
+// kept in ancillary chunks, in the style of APNG:
+//
+//   - anim, before the pixel data, holds the number of frames (uint32) and
+//     the loop count (int32).
+//   - fctl, one per frame, holds the frame number (uint32), its position
+//     on the canvas (int32 x, y), its size (uint32 width, height), its delay
+//     in 100ths of a second (uint32), its disposal and its color type. The
+//     fctl of the first frame comes before the pixel data and covers the
+//     whole canvas.
+//   - fplt holds the palette of a palette frame, laid out like PLTE.
+//   - fdat holds the pixels of every frame after the first, compressed as
+//     a single tile with the codec and bit depth given in HEAD.
+const (
+	chunkAnimation    = "anim"
+	chunkFrameControl = "fctl"
+	chunkFramePalette = "fplt"
+	chunkFrameData    = "fdat"
+)
+
+// Animation is a sequence of frames drawn on a shared canvas, the HUH
+// counterpart of gif.GIF.
+type Animation struct {
+	// Image holds the frames. Their bounds place them on the canvas.
+	Image []image.Image
+	// Delay is the time each frame is shown, in 100ths of a second. It may
+	// be nil.
+	Delay []int
+	// Disposal says what happens to each frame before the next one is
+	// drawn, using the values of gif.DisposalNone, gif.DisposalBackground
+	// and gif.DisposalPrevious, or zero if unspecified. It may be nil.
+	Disposal []byte
+	// LoopCount has the meaning it has in gif.GIF: 0 loops forever, -1
+	// shows every frame once and n > 0 shows them n+1 times.
+	LoopCount int
+	// Config.Width and Config.Height are the size of the canvas, which
+	// starts at (0, 0). If they are zero, the canvas is the union of the
+	// frame bounds.
+	Config image.Config
+}
+
+// frameControl is a decoded fctl chunk with the palette and pixel data
+// that belong to it.
+type frameControl struct {
+	rect      image.Rectangle
+	delay     int
+	disposal  byte
+	colorType ColorType
+	palette   color.Palette
+	data      []byte
+}
+
+// animation is what the decoder collects from the animation chunks.
+type animation struct {
+	frames    int
+	loopCount int
+	controls  []frameControl
+	// decoded is the size of every frame decoded so far, for the limits.
+	decoded uint64
+}
+
+func isFrameChunk(typ string) bool {
+	switch typ {
+	case chunkAnimation, chunkFrameControl, chunkFramePalette, chunkFrameData:
+		return true
+	}
+	return false
+}
+
+// readFrameChunk handles the animation chunks of files opened for
+// DecodeAll and Verify.
+func (h *header) readFrameChunk(c *chunkReader, limits *Limits) error {
+	section := chunkSection(c.typ)
+	// None of these chunks is larger than a palette except fdat, whose
+	// size is bounded once its frame is known.
+	if c.typ != chunkFrameData && c.remaining > 4*256 {
+		return damaged(section, errors.New("invalid chunk length"))
+	}
+	a := h.anim
+	var current *frameControl
+	if a != nil && len(a.controls) > 0 {
+		current = &a.controls[len(a.controls)-1]
+	}
+
+	switch c.typ {
+	case chunkAnimation:
+		data, err := c.body()
+		if err != nil {
+			return err
+		}
+		if a != nil || len(data) != 8 {
+			return damaged(section, errors.New("invalid anim chunk"))
+		}
+		frames := binary.LittleEndian.Uint32(data)
+		if frames == 0 || frames > maxTiles {
+			return damaged(section, fmt.Errorf("invalid frame count %d", frames))
+		}
+		h.anim = &animation{
+			frames:    int(frames),
+			loopCount: int(int32(binary.LittleEndian.Uint32(data[4:]))),
+			decoded:   uint64(h.width) * uint64(h.height) * uint64(h.format().decodedBytesPerPixel()),
+		}
+		return nil
+
+	case chunkFrameControl:
+		data, err := c.body()
+		if err != nil {
+			return err
+		}
+		if a == nil || len(data) != 26 || len(a.controls) == a.frames {
+			return damaged(section, errors.New("unexpected fctl chunk"))
+		}
+		if current != nil && len(a.controls) > 1 && current.data == nil {
+			return damaged(section, fmt.Errorf("frame %d has no fdat chunk", len(a.controls)-1))
+		}
+		if seq := binary.LittleEndian.Uint32(data); seq != uint32(len(a.controls)) {
+			return damaged(section, fmt.Errorf("frame %d out of order", seq))
+		}
+		x, y := int32(binary.LittleEndian.Uint32(data[4:])), int32(binary.LittleEndian.Uint32(data[8:]))
+		w, hh := binary.LittleEndian.Uint32(data[12:]), binary.LittleEndian.Uint32(data[16:])
+		f := frameControl{
+			delay:     int(binary.LittleEndian.Uint32(data[20:])),
+			disposal:  data[24],
+			colorType: ColorType(data[25]),
+		}
+		canvas := image.Rect(0, 0, int(h.width), int(h.height))
+		if x < 0 || y < 0 || uint64(x)+uint64(w) > uint64(h.width) || uint64(y)+uint64(hh) > uint64(h.height) || w == 0 || hh == 0 {
+			return damaged(section, errors.New("frame outside the canvas"))
+		}
+		f.rect = image.Rect(int(x), int(y), int(x)+int(w), int(y)+int(hh))
+		if f.colorType > ColorPalette || (f.colorType == ColorPalette && h.depth != 8) {
+			return fmt.Errorf("unsupported HUH color type: %d", f.colorType)
+		}
+		if len(a.controls) == 0 {
+			if f.rect != canvas || f.colorType != h.colorType {
+				return damaged(section, errors.New("first frame does not match HEAD"))
+			}
+		} else {
+			a.decoded += uint64(w) * uint64(hh) * uint64(pixelFormat{colorType: f.colorType, depth: int(h.depth)}.decodedBytesPerPixel())
+			if err := limits.checkDecoded(a.decoded); err != nil {
+				return err
+			}
+		}
+		a.controls = append(a.controls, f)
+		return nil
+
+	case chunkFramePalette:
+		if current == nil || len(a.controls) == 1 || current.colorType != ColorPalette || current.palette != nil {
+			return damaged(section, errors.New("unexpected fplt chunk"))
+		}
+		data, err := c.body()
+		if err != nil {
+			return err
+		}
+		if current.palette, err = decodePalette(data); err != nil {
+			return damaged(section, err)
+		}
+		return nil
+
+	case chunkFrameData:
+		if current == nil || len(a.controls) == 1 || current.data != nil || (current.colorType == ColorPalette && current.palette == nil) {
+			return damaged(section, errors.New("unexpected fdat chunk"))
+		}
+		f := pixelFormat{colorType: current.colorType, depth: int(h.depth)}
+		if c.remaining > maxPacked(current.rect.Dy()*(1+current.rect.Dx()*f.bytesPerPixel())) {
+			return damaged(section, errors.New("invalid chunk length"))
+		}
+		data, err := c.body()
+		if err != nil {
+			return err
+		}
+		current.data = data
+		return nil
+	}
+	return c.end()
+}
+
+// checkFrames checks, once END! has been read, that every frame announced
+// by the anim chunk is there.
+func (h *header) checkFrames() error {
+	a := h.anim
+	if a == nil {
+		return nil
+	}
+	if len(a.controls) != a.frames || (a.frames > 1 && a.controls[a.frames-1].data == nil) {
+		return damaged(chunkSection(chunkAnimation), fmt.Errorf("%d of %d frames present", len(a.controls), a.frames))
+	}
+	return nil
+}
+
+// frame returns the header describing frame i > 0 on its own, as a file
+// with a single tile.
+func (h *header) frame(i int) *header {
+	f := h.anim.controls[i]
+	fh := *h
+	fh.colorType = f.colorType
+	fh.palette = f.palette
+	fh.origin = h.origin.Add(f.rect.Min)
+	fh.width, fh.height = uint32(f.rect.Dx()), uint32(f.rect.Dy())
+	fh.tileWidth, fh.tileHeight = fh.width, fh.height
+	fh.anim = nil
+	return &fh
+}
+
+// decodeFrames decodes every frame after the first.
+func (h *header) decodeFrames(fn func(i int, fh *header) rowFunc) error {
+	if h.anim == nil {
+		return nil
+	}
+	for i := 1; i < len(h.anim.controls); i++ {
+		fh := h.frame(i)
+		if err := decodeTile(fh, image.Rect(0, 0, int(fh.width), int(fh.height)), h.anim.controls[i].data, fn(i, fh)); err != nil {
+			return damaged(SectionPixels, fmt.Errorf("frame %d: %w", i, err))
+		}
+	}
+	return nil
+}
+
+// DecodeAll reads every frame of a HUH file, along with its metadata.
+// Files without animation yield a single frame. The first frame covers the
+// whole canvas; later frames have the bounds they were encoded with.
+func (d *Decoder) DecodeAll(r io.Reader) (*Animation, Metadata, error) {
+	h, src, err := d.open(r, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	first, fn := h.newImage(h.bounds())
+	if err := src(d, fn); err != nil {
+		return nil, nil, err
+	}
+
+	anim := &Animation{
+		Image:  []image.Image{first},
+		Config: image.Config{ColorModel: h.format().colorModel(), Width: int(h.width), Height: int(h.height)},
+	}
+	if h.anim == nil {
+		anim.Delay, anim.Disposal = []int{0}, []byte{0}
+		return anim, h.metadata, nil
+	}
+	anim.LoopCount = h.anim.loopCount
+	for _, f := range h.anim.controls {
+		anim.Delay = append(anim.Delay, f.delay)
+		anim.Disposal = append(anim.Disposal, f.disposal)
+	}
+	err = h.decodeFrames(func(i int, fh *header) rowFunc {
+		img, fn := fh.newImage(fh.bounds())
+		anim.Image = append(anim.Image, img)
+		return fn
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return anim, h.metadata, nil
+}
+
+// DecodeAll reads every frame of the HUH file read from r.
+func DecodeAll(r io.Reader) (*Animation, error) {
+	anim, _, err := new(Decoder).DecodeAll(r)
+	return anim, err
+}
+
+// EncodeAll writes the frames of anim to w. Options apply to every frame;
+// the tile size and concurrency only to the first, which is padded to the
+// size of the canvas if it does not cover it.
+func EncodeAll(w io.Writer, anim *Animation, opts *Options) error {
+	n := len(anim.Image)
+	if n == 0 {
+		return errors.New("HUH animation has no frames")
+	}
+	if (anim.Delay != nil && len(anim.Delay) != n) || (anim.Disposal != nil && len(anim.Disposal) != n) {
+		return errors.New("HUH animation has mismatched delay or disposal lengths")
+	}
+
+	var canvas image.Rectangle
+	for _, img := range anim.Image {
+		canvas = canvas.Union(img.Bounds())
+	}
+	if anim.Config.Width > 0 || anim.Config.Height > 0 {
+		c := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+		if !canvas.In(c) {
+			return fmt.Errorf("HUH animation frames %v do not fit the %dx%d canvas", canvas, c.Dx(), c.Dy())
+		}
+		canvas = c
+	}
+
+	first := anim.Image[0]
+	if first.Bounds() != canvas {
+		first = fillCanvas(first, canvas)
+	}
+	return encode(w, first, opts, anim)
+}
+
+// fillCanvas draws img on a transparent canvas. Palette images stay palette
+// images if their palette has a transparent entry.
+func fillCanvas(img image.Image, canvas image.Rectangle) image.Image {
+	var dst draw.Image
+	if p, ok := img.(*image.Paletted); ok {
+		for i, c := range p.Palette {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				m := image.NewPaletted(canvas, p.Palette)
+				for j := range m.Pix {
+					m.Pix[j] = uint8(i)
+				}
+				dst = m
+				break
+			}
+		}
+	}
+	if dst == nil && depthOf(img) == 16 {
+		dst = image.NewNRGBA64(canvas)
+	} else if dst == nil {
+		dst = image.NewNRGBA(canvas)
+	}
+	draw.Draw(dst, img.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst
+}
+
+// frameControlChunk encodes the fctl chunk of frame i of anim.
+func frameControlChunk(anim *Animation, i int, rect image.Rectangle, colorType ColorType) []byte {
+	data := make([]byte, 26)
+	binary.LittleEndian.PutUint32(data[0:], uint32(i))
+	binary.LittleEndian.PutUint32(data[4:], uint32(int32(rect.Min.X)))
+	binary.LittleEndian.PutUint32(data[8:], uint32(int32(rect.Min.Y)))
+	binary.LittleEndian.PutUint32(data[12:], uint32(rect.Dx()))
+	binary.LittleEndian.PutUint32(data[16:], uint32(rect.Dy()))
+	if anim.Delay != nil {
+		binary.LittleEndian.PutUint32(data[20:], uint32(anim.Delay[i]))
+	}
+	if anim.Disposal != nil {
+		data[24] = anim.Disposal[i]
+	}
+	data[25] = byte(colorType)
+	return data
+}
+
+// writeAnimation writes the anim chunk and the fctl chunk of the first
+// frame, whose color type is colorType.
+func writeAnimation(w io.Writer, anim *Animation, canvas image.Rectangle, colorType ColorType) error {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data, uint32(len(anim.Image)))
+	binary.LittleEndian.PutUint32(data[4:], uint32(int32(anim.LoopCount)))
+	if err := writeChunk(w, chunkAnimation, data); err != nil {
+		return err
+	}
+	return writeChunk(w, chunkFrameControl, frameControlChunk(anim, 0, canvas.Sub(canvas.Min), colorType))
+}
+
+// writeFrame writes the chunks of frame i > 0. Its position is relative to
+// the canvas origin, and its samples have the depth of the first frame.
+func writeFrame(w io.Writer, anim *Animation, i int, canvas image.Point, depth int, opts *Options, level int) error {
+	img := anim.Image[i]
+	if img.Bounds().Empty() {
+		return fmt.Errorf("HUH animation frame %d is empty", i)
+	}
+	f, err := formatOf(img, opts.ColorType, depth)
+	if err != nil {
+		return fmt.Errorf("HUH animation frame %d: %w", i, err)
+	}
+	rect := img.Bounds().Sub(canvas)
+	if err := writeChunk(w, chunkFrameControl, frameControlChunk(anim, i, rect, f.colorType)); err != nil {
+		return err
+	}
+	if f.colorType == ColorPalette {
+		if err := writeChunk(w, chunkFramePalette, encodePalette(f.palette)); err != nil {
+			return err
+		}
+	}
+	e := &tileEncoder{img: img, format: f, codec: opts.Compression, level: level}
+	data, err := e.encode(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	if err != nil {
+		return err
+	}
+	return writeChunk(w, chunkFrameData, data)
+}
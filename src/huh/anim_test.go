@@ -0,0 +1,99 @@
+package huh
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"reflect"
+	"testing"
+)
+
+func TestAnimation(t *testing.T) {
+	palette := color.Palette{color.NRGBA{}, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 255}}
+	second := image.NewPaletted(image.Rect(5, 3, 12, 10), palette)
+	for i := range second.Pix {
+		second.Pix[i] = uint8(i % 3)
+	}
+	third := image.NewGray(image.Rect(14, 0, 20, 15))
+	for i := range third.Pix {
+		third.Pix[i] = uint8(i * 5)
+	}
+	anim := &Animation{
+		Image:     []image.Image{testImage(image.Rect(0, 0, 20, 15)), second, third},
+		Delay:     []int{10, 20, 35},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious},
+		LoopCount: 2,
+	}
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, anim, &Options{TileSize: 8}); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	got, err := DecodeAll(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Image) != len(anim.Image) {
+		t.Fatalf("%d frames, want %d", len(got.Image), len(anim.Image))
+	}
+	for i, img := range got.Image {
+		sameImage(t, "frame", img, anim.Image[i])
+	}
+	if !reflect.DeepEqual(got.Delay, anim.Delay) || !reflect.DeepEqual(got.Disposal, anim.Disposal) || got.LoopCount != anim.LoopCount {
+		t.Errorf("timing %v %v %d, want %v %v %d", got.Delay, got.Disposal, got.LoopCount, anim.Delay, anim.Disposal, anim.LoopCount)
+	}
+	if got.Config.Width != 20 || got.Config.Height != 15 {
+		t.Errorf("canvas %dx%d, want 20x15", got.Config.Width, got.Config.Height)
+	}
+
+	// Decoders that know nothing of animation see the first frame.
+	still, err := Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameImage(t, "Decode", still, anim.Image[0])
+	if err := Verify(bytes.NewReader(file)); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	// A first frame smaller than the canvas is padded with transparency.
+	small := &Animation{Image: []image.Image{second, third}, Config: image.Config{Width: 20, Height: 15}}
+	buf.Reset()
+	if err := EncodeAll(&buf, small, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = DecodeAll(&buf); err != nil {
+		t.Fatal(err)
+	}
+	first := got.Image[0]
+	if first.Bounds() != image.Rect(0, 0, 20, 15) {
+		t.Fatalf("padded first frame has bounds %v", first.Bounds())
+	}
+	sameImage(t, "padded", first.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(second.Bounds()), second)
+	if _, _, _, a := first.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("padding has alpha %d", a)
+	}
+
+	// Still files decode to a single frame.
+	buf.Reset()
+	if err := Encode(&buf, third, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = DecodeAll(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Image) != 1 || len(got.Delay) != 1 || len(got.Disposal) != 1 {
+		t.Errorf("still file gives %d frames", len(got.Image))
+	}
+
+	if err := EncodeAll(&buf, &Animation{Image: anim.Image, Delay: []int{1}}, nil); err == nil {
+		t.Error("mismatched delays accepted")
+	}
+	if err := EncodeAll(&buf, &Animation{Image: anim.Image, Config: image.Config{Width: 10, Height: 10}}, nil); err == nil {
+		t.Error("frames outside the canvas accepted")
+	}
+}
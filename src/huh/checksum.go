@@ -0,0 +1,47 @@
+package huh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Sections of a HUH file that carry their own checksum since v3.
+const (
+	SectionHeader   = "header"
+	SectionMetadata = "metadata"
+	SectionPixels   = "pixel data"
+)
+
+// ErrChecksum is wrapped by a SectionError when the data of a section does
+// not match its stored CRC32.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// A SectionError reports that one section of a HUH file is damaged, either
+// because it is truncated or because its checksum does not match.
+type SectionError struct {
+	Section string
+	Err     error
+}
+
+func (e *SectionError) Error() string {
+	return fmt.Sprintf("damaged %s section: %v", e.Section, e.Err)
+}
+
+func (e *SectionError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes every SectionError match ErrCorrupt.
+func (e *SectionError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// damaged wraps err in a SectionError, reporting a clean EOF in the middle
+// of a section as truncation.
+func damaged(section string, err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &SectionError{Section: section, Err: err}
+}
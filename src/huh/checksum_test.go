@@ -0,0 +1,61 @@
+package huh
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	var buf bytes.Buffer
+	meta := Metadata{"title": "checksums"}
+	if err := Encode(&buf, testImage(image.Rect(0, 0, 31, 19)), &Options{Metadata: meta}); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	if err := Verify(bytes.NewReader(valid)); err != nil {
+		t.Fatalf("intact file: %v", err)
+	}
+
+	// A flipped byte in the body or the CRC32 of a chunk is blamed on
+	// the section the chunk belongs to. Inflate may notice damage to DATA
+	// before its CRC32 does; that is checked below.
+	for _, c := range splitChunks(t, valid) {
+		for _, at := range []int{c.at + 8, c.at + c.size - 1} {
+			if at == c.at+8 && (c.size == 12 || c.typ == chunkData) {
+				continue
+			}
+			file := bytes.Clone(valid)
+			file[at] ^= 0x10
+			err := Verify(bytes.NewReader(file))
+			var serr *SectionError
+			if !errors.As(err, &serr) || serr.Section != chunkSection(c.typ) || !errors.Is(err, ErrChecksum) {
+				t.Errorf("byte %d of %s flipped: got %v, want a checksum mismatch in the %s section", at-c.at, c.typ, err, chunkSection(c.typ))
+			}
+		}
+	}
+
+	// Any damage to the pixel data is caught, by inflate or by the CRC32.
+	data := locateChunk(t, valid, chunkData)
+	for at := data.at + 8; at < data.at+data.size; at++ {
+		file := bytes.Clone(valid)
+		file[at] ^= 0x01
+		var serr *SectionError
+		if err := Verify(bytes.NewReader(file)); !errors.As(err, &serr) || serr.Section != SectionPixels {
+			t.Fatalf("byte %d flipped: got %v, want damaged pixel data", at, err)
+		}
+	}
+
+	for n := len(Magic) + 1; n < len(valid); n++ {
+		if err := Verify(bytes.NewReader(valid[:n])); !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("truncated to %d bytes: got %v, want ErrCorrupt", n, err)
+		}
+	}
+	var serr *SectionError
+	err := Verify(bytes.NewReader(valid[:data.at+data.size/2]))
+	if !errors.As(err, &serr) || serr.Section != SectionPixels || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated: got %v, want truncated pixel data", err)
+	}
+}
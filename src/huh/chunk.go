@@ -0,0 +1,214 @@
+package huh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Chunk types of a v3 file. As in PNG, a type whose first letter is upper
+// case is critical: a decoder that does not know it must refuse the file.
+// Ancillary (lower case) chunks can be skipped by decoders that do not
+// understand them.
+const (
+	chunkHead     = "HEAD"
+	chunkPalette  = "PLTE"
+	chunkMetadata = "meta"
+	chunkData     = "DATA"
+	chunkEnd      = "END!"
+)
+
+func isCritical(typ string) bool {
+	return typ[0] >= 'A' && typ[0] <= 'Z'
+}
+
+// chunkSection names the part of the file a chunk belongs to, for errors.
+func chunkSection(typ string) string {
+	switch typ {
+	case chunkHead:
+		return SectionHeader
+	case chunkMetadata:
+		return SectionMetadata
+	case chunkData:
+		return SectionPixels
+	}
+	return fmt.Sprintf("%q chunk", typ)
+}
+
+// writeChunk writes one chunk: its length, type, data and the CRC32 of the
+// type and data.
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], typ)
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(data)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, crc.Sum32())
+}
+
+// chunkReader walks the chunks of a v3 file. After next returns, the body
+// of the current chunk is read through Read, and end checks its CRC.
+type chunkReader struct {
+	br        *bufio.Reader
+	crc       hash.Hash32
+	typ       string
+	remaining uint32
+}
+
+func newChunkReader(br *bufio.Reader) *chunkReader {
+	return &chunkReader{br: br, crc: crc32.NewIEEE()}
+}
+
+// next reads the length and type of the following chunk.
+func (c *chunkReader) next() error {
+	var hdr [8]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		// Every file must run up to its END! chunk.
+		return damaged(chunkSection(chunkEnd), err)
+	}
+	typ := string(hdr[4:])
+	for i := 0; i < len(typ); i++ {
+		if typ[i] < 0x21 || typ[i] > 0x7e {
+			return damaged(chunkSection(typ), errors.New("invalid chunk type"))
+		}
+	}
+	c.typ = typ
+	c.remaining = binary.LittleEndian.Uint32(hdr[:4])
+	c.crc.Reset()
+	c.crc.Write(hdr[4:])
+	return nil
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.remaining == 0 {
+		return 0, io.EOF
+	}
+	if uint32(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.crc.Write(p[:n])
+	c.remaining -= uint32(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// body reads the whole body of the current chunk.
+func (c *chunkReader) body() ([]byte, error) {
+	data := make([]byte, c.remaining)
+	if _, err := io.ReadFull(c, data); err != nil {
+		return nil, damaged(chunkSection(c.typ), err)
+	}
+	return data, c.end()
+}
+
+// end skips what is left of the current chunk and checks its CRC.
+func (c *chunkReader) end() error {
+	if _, err := io.Copy(io.Discard, c); err != nil {
+		return damaged(chunkSection(c.typ), err)
+	}
+	var stored uint32
+	if err := binary.Read(c.br, binary.LittleEndian, &stored); err != nil {
+		return damaged(chunkSection(c.typ), err)
+	}
+	if stored != c.crc.Sum32() {
+		return damaged(chunkSection(c.typ), ErrChecksum)
+	}
+	return nil
+}
+
+// dataReader presents the bodies of consecutive DATA chunks as one stream,
+// which ends at the first chunk of another type. It implements
+// io.ByteReader so flate does not read past the stream.
+type dataReader struct {
+	c *chunkReader
+	// done is set once the chunk after the last DATA chunk has been read.
+	done bool
+}
+
+func (d *dataReader) advance() error {
+	for d.c.remaining == 0 {
+		if d.done {
+			return io.EOF
+		}
+		if err := d.c.end(); err != nil {
+			return err
+		}
+		if err := d.c.next(); err != nil {
+			return err
+		}
+		if d.c.typ != chunkData {
+			d.done = true
+			return io.EOF
+		}
+	}
+	return nil
+}
+
+func (d *dataReader) Read(p []byte) (int, error) {
+	if err := d.advance(); err != nil {
+		return 0, err
+	}
+	return d.c.Read(p)
+}
+
+func (d *dataReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(d, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// findChunk reads the chunks of a HUH file that come before its pixel data
+// up to the first one of type typ, and returns the chunkReader with its
+// body ready to be read. It returns a nil chunkReader if the file has no
+// such chunk, which is always the case for v2 files.
+func findChunk(r io.Reader, typ string) (*chunkReader, error) {
+	br := bufio.NewReader(r)
+	prefix := make([]byte, len(Magic)+1)
+	if _, err := io.ReadFull(br, prefix); err != nil || string(prefix[:len(Magic)]) != Magic {
+		return nil, errors.New("invalid HUH file: bad magic number")
+	}
+	switch version := prefix[len(Magic)]; {
+	case version == 2:
+		return nil, nil
+	case version != Version:
+		return nil, fmt.Errorf("unsupported HUH version: %d", version)
+	}
+
+	c := newChunkReader(br)
+	if err := c.next(); err != nil {
+		return nil, err
+	}
+	if c.typ != chunkHead {
+		return nil, damaged(SectionHeader, errors.New("missing HEAD chunk"))
+	}
+	for {
+		if err := c.end(); err != nil {
+			return nil, err
+		}
+		if err := c.next(); err != nil {
+			return nil, err
+		}
+		switch c.typ {
+		case typ:
+			return c, nil
+		case chunkIndex, chunkData, chunkEnd:
+			return nil, nil
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package huh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"strings"
+	"testing"
+)
+
+// fileChunk is a chunk of an encoded file: its type and the offset and
+// length of the whole chunk, from its length field to its CRC32.
+type fileChunk struct {
+	typ      string
+	at, size int
+}
+
+// splitChunks returns the chunks that follow the magic number and version
+// of file.
+func splitChunks(t *testing.T, file []byte) []fileChunk {
+	t.Helper()
+	var chunks []fileChunk
+	for at := len(Magic) + 1; at < len(file); {
+		if len(file)-at < 12 {
+			t.Fatalf("%d bytes left after the last chunk", len(file)-at)
+		}
+		size := 12 + int(binary.LittleEndian.Uint32(file[at:]))
+		chunks = append(chunks, fileChunk{string(file[at+4 : at+8]), at, size})
+		at += size
+	}
+	return chunks
+}
+
+// locateChunk returns the first chunk of type typ in file.
+func locateChunk(t *testing.T, file []byte, typ string) fileChunk {
+	t.Helper()
+	for _, c := range splitChunks(t, file) {
+		if c.typ == typ {
+			return c
+		}
+	}
+	t.Fatalf("no %s chunk", typ)
+	return fileChunk{}
+}
+
+// insertChunk returns file with a chunk of type typ holding data inserted
+// at offset at.
+func insertChunk(file []byte, at int, typ string, data []byte) []byte {
+	var chunk bytes.Buffer
+	writeChunk(&chunk, typ, data)
+	out := append(bytes.Clone(file[:at]), chunk.Bytes()...)
+	return append(out, file[at:]...)
+}
+
+func TestUnknownChunks(t *testing.T) {
+	img := testImage(image.Rect(0, 0, 19, 11))
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Metadata: Metadata{"title": "chunks"}}); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	data := locateChunk(t, file, chunkData)
+	end := locateChunk(t, file, chunkEnd)
+
+	// Unknown ancillary chunks are skipped wherever they are.
+	for _, at := range []int{data.at, end.at} {
+		got, err := Decode(bytes.NewReader(insertChunk(file, at, "tEXt", []byte("anything"))))
+		if err != nil {
+			t.Fatalf("ancillary chunk at %d: %v", at, err)
+		}
+		sameImage(t, "ancillary chunk", got, img)
+	}
+
+	// Unknown critical chunks are refused, but not as damage.
+	for _, at := range []int{data.at, end.at} {
+		damagedFile := insertChunk(file, at, "NEW!", []byte{1, 2, 3})
+		_, err := Decode(bytes.NewReader(damagedFile))
+		if err == nil || errors.Is(err, ErrCorrupt) || !strings.Contains(err.Error(), `"NEW!"`) {
+			t.Errorf("critical chunk at %d: got %v", at, err)
+		}
+	}
+
+	// A critical chunk with a bad CRC is reported as damaged instead.
+	damagedFile := insertChunk(file, data.at, "NEW!", []byte{1, 2, 3})
+	damagedFile[data.at+8] ^= 1
+	if _, err := Decode(bytes.NewReader(damagedFile)); !errors.Is(err, ErrChecksum) {
+		t.Errorf("damaged critical chunk: got %v, want ErrChecksum", err)
+	}
+
+	// HEAD must come first and END! last.
+	head := locateChunk(t, file, chunkHead)
+	for name, bad := range map[string][]byte{
+		"duplicate HEAD":    insertChunk(file, data.at, chunkHead, file[head.at+8:head.at+head.size-4]),
+		"no HEAD":           append([]byte(Magic+"\x03"), file[head.at+head.size:]...),
+		"no END!":           file[:end.at],
+		"DATA after pixels": insertChunk(file, end.at, chunkData, []byte{0}),
+	} {
+		if _, err := Decode(bytes.NewReader(bad)); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: got %v, want ErrCorrupt", name, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package huh
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// Compression selects the codec for the pixel stream. It is recorded in
+// the HEAD chunk together with the compression level.
+type Compression uint8
+
+const (
+	// CompressionDeflate is DEFLATE at levels 1 to 9, the default.
+	CompressionDeflate Compression = 0
+	// CompressionNone stores the filtered pixels without compression.
+	CompressionNone Compression = 1
+	// CompressionLZ is a fast LZ77 codec, see lz.go.
+	CompressionLZ Compression = 2
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionDeflate:
+		return "deflate"
+	case CompressionNone:
+		return "none"
+	case CompressionLZ:
+		return "lz"
+	}
+	return fmt.Sprintf("Compression(%d)", uint8(c))
+}
+
+// nopWriteCloser is the "compressor" of CompressionNone.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newCompressor wraps w in the codec c. Level only applies to DEFLATE.
+func newCompressor(w io.Writer, c Compression, level int) (io.WriteCloser, error) {
+	switch c {
+	case CompressionDeflate:
+		return flate.NewWriter(w, level)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionLZ:
+		return newLZWriter(w), nil
+	}
+	return nil, fmt.Errorf("unsupported HUH compression: %v", c)
+}
+
+// newDecompressor wraps r, which must implement io.ByteReader so flate
+// does not read past the end of the pixel stream.
+func newDecompressor(r io.Reader, c Compression) io.Reader {
+	switch c {
+	case CompressionNone:
+		return r
+	case CompressionLZ:
+		return newLZReader(r)
+	}
+	return flate.NewReader(r)
+}
@@ -0,0 +1,458 @@
+package huh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// header is everything that precedes the compressed pixel data.
+type header struct {
+	colorType ColorType
+	metadata  Metadata
+	width     uint32
+	height    uint32
+	origin    image.Point
+	filter    uint8
+	codec     Compression
+	level     uint8
+	// depth is the number of bits per sample, 8 or 16.
+	depth uint8
+	// quality is the quality of lossy files, from 1 to 100, and zero for
+	// lossless ones.
+	quality uint8
+	// palette comes from the PLTE chunk of palette images.
+	palette color.Palette
+	// keepFrames asks for the animation chunks to be read into anim
+	// rather than skipped.
+	keepFrames bool
+	anim       *animation
+	// tileWidth and tileHeight are zero for files with a single pixel
+	// stream. tileSizes comes from the INDX chunk of tiled files.
+	tileWidth  uint32
+	tileHeight uint32
+	tileSizes  []uint32
+}
+
+// check validates the fields that every version shares against limits.
+func (h *header) check(limits *Limits) error {
+	if h.colorType > ColorPalette {
+		return fmt.Errorf("unsupported HUH color type: %d", h.colorType)
+	}
+	if h.filter > filterMethodLossy {
+		return fmt.Errorf("unsupported HUH filter method: %d", h.filter)
+	}
+	if h.codec > CompressionLZ {
+		return fmt.Errorf("unsupported HUH compression: %v", h.codec)
+	}
+	if h.tiled() {
+		if h.tileWidth == 0 || h.tileHeight == 0 || h.filter == filterMethodNone {
+			return damaged(SectionHeader, errors.New("invalid tile layout"))
+		}
+		cols := (uint64(h.width) + uint64(h.tileWidth) - 1) / uint64(h.tileWidth)
+		rows := (uint64(h.height) + uint64(h.tileHeight) - 1) / uint64(h.tileHeight)
+		if n := cols * rows; n > maxTiles {
+			return fmt.Errorf("%w: %d tiles", ErrTooLarge, n)
+		}
+	}
+	if (h.depth != 8 && h.depth != 16) || (h.colorType == ColorPalette && h.depth != 8) {
+		return fmt.Errorf("unsupported HUH bit depth: %d", h.depth)
+	}
+	if h.filter == filterMethodLossy {
+		// Only tiles have the plane layout, and planes only colors.
+		if !h.tiled() || h.colorType == ColorPalette || h.depth != 8 || h.quality < 1 || h.quality > 100 {
+			return damaged(SectionHeader, errors.New("invalid lossy layout"))
+		}
+	}
+	return limits.checkImage(h.width, h.height, h.format().decodedBytesPerPixel())
+}
+
+func (h *header) tiled() bool {
+	return h.tileWidth != 0 || h.tileHeight != 0
+}
+
+func (h *header) grid() tileGrid {
+	return tileGrid{width: int(h.width), height: int(h.height), tileW: int(h.tileWidth), tileH: int(h.tileHeight)}
+}
+
+func (h *header) format() pixelFormat {
+	return pixelFormat{colorType: h.colorType, depth: int(h.depth), palette: h.palette}
+}
+
+// rowFunc receives the unfiltered pixels of row y, starting at column x0.
+// Both are relative to the image origin. Tiled files call it from several
+// goroutines at once, but never twice for the same pixels.
+type rowFunc func(y, x0 int, row []byte)
+
+// pixelSource feeds every row of an image to fn and then checks the rest
+// of the file.
+type pixelSource func(d *Decoder, fn rowFunc) error
+
+// pixelStream is the decompressed pixel data of an untiled file. finish is
+// called once every row has been read, to check whatever follows.
+type pixelStream struct {
+	io.Reader
+	finish func() error
+}
+
+// Decoder decodes HUH files. The zero value is ready to use.
+type Decoder struct {
+	// Progress, if non-nil, is called with values in [0, 1] while pixels
+	// are being decompressed.
+	Progress func(float32)
+	// Limits bounds the size of the files the Decoder accepts. If nil,
+	// DefaultLimits is used.
+	Limits *Limits
+	// Concurrency is the number of tiles inflated at once. Zero means
+	// runtime.GOMAXPROCS(0).
+	Concurrency int
+}
+
+func (d *Decoder) limits() *Limits {
+	if d.Limits == nil {
+		return &DefaultLimits
+	}
+	return d.Limits
+}
+
+// open reads the magic number and version of a file and dispatches to the
+// reader for its layout. If frames is set, the frames of animated files
+// are kept for DecodeAll.
+func (d *Decoder) open(r io.Reader, frames bool) (*header, pixelSource, error) {
+	return d.openBuffered(bufio.NewReader(r), frames)
+}
+
+func (d *Decoder) openBuffered(br *bufio.Reader, frames bool) (*header, pixelSource, error) {
+	prefix := make([]byte, len(Magic)+1)
+	if _, err := io.ReadFull(br, prefix); err != nil || string(prefix[:len(Magic)]) != Magic {
+		return nil, nil, errors.New("invalid HUH file: bad magic number")
+	}
+
+	switch version := prefix[len(Magic)]; {
+	case version == 2:
+		h, ps, err := openLegacy(br, d.limits())
+		if err != nil {
+			return nil, nil, err
+		}
+		return h, streamSource(h, ps), nil
+	case version == Version:
+		return openChunked(br, d.limits(), frames)
+	default:
+		return nil, nil, fmt.Errorf("unsupported HUH version: %d", version)
+	}
+}
+
+// openChunked reads the chunks of a v3 file up to the first DATA chunk.
+func openChunked(br *bufio.Reader, limits *Limits, frames bool) (*header, pixelSource, error) {
+	c := newChunkReader(br)
+	if err := c.next(); err != nil {
+		return nil, nil, err
+	}
+	if c.typ != chunkHead {
+		return nil, nil, damaged(SectionHeader, errors.New("missing HEAD chunk"))
+	}
+	// HEAD may grow in later revisions; the bound only stops a corrupt
+	// length from allocating much.
+	if c.remaining < 17 || c.remaining > 1024 {
+		return nil, nil, damaged(SectionHeader, errors.New("invalid HEAD chunk length"))
+	}
+	head, err := c.body()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := &header{keepFrames: frames}
+	var origin [2]int32
+	hr := bytes.NewReader(head)
+	binary.Read(hr, binary.LittleEndian, &h.width)
+	binary.Read(hr, binary.LittleEndian, &h.height)
+	binary.Read(hr, binary.LittleEndian, &origin)
+	binary.Read(hr, binary.LittleEndian, &h.colorType)
+	// Fields appended to HEAD later default to zero in shorter chunks.
+	binary.Read(hr, binary.LittleEndian, &h.filter)
+	binary.Read(hr, binary.LittleEndian, &h.codec)
+	binary.Read(hr, binary.LittleEndian, &h.level)
+	binary.Read(hr, binary.LittleEndian, &h.tileWidth)
+	binary.Read(hr, binary.LittleEndian, &h.tileHeight)
+	binary.Read(hr, binary.LittleEndian, &h.depth)
+	binary.Read(hr, binary.LittleEndian, &h.quality)
+	if h.depth == 0 {
+		// HEAD chunks written before the bit depth field hold 8-bit samples.
+		h.depth = 8
+	}
+	h.origin = image.Pt(int(origin[0]), int(origin[1]))
+	if err := h.check(limits); err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		if err := c.next(); err != nil {
+			return nil, nil, err
+		}
+		if c.typ == chunkData {
+			break
+		}
+		if c.typ == chunkEnd {
+			// An empty image has no tiles, so END! follows INDX directly.
+			if h.tileSizes != nil && len(h.tileSizes) == 0 {
+				break
+			}
+			return nil, nil, damaged(SectionPixels, errors.New("missing DATA chunk"))
+		}
+		if c.typ == chunkPalette && h.colorType == ColorPalette && h.palette == nil {
+			// Checked before reading, so a corrupt length cannot allocate.
+			if c.remaining == 0 || c.remaining > 4*256 || c.remaining%4 != 0 {
+				return nil, nil, damaged(chunkSection(chunkPalette), errors.New("invalid palette length"))
+			}
+			data, err := c.body()
+			if err != nil {
+				return nil, nil, err
+			}
+			if h.palette, err = decodePalette(data); err != nil {
+				return nil, nil, damaged(chunkSection(chunkPalette), err)
+			}
+			continue
+		}
+		if c.typ == chunkIndex && h.tiled() && h.tileSizes == nil {
+			if err := h.readIndex(c); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if err := h.readChunk(c, limits); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if h.colorType == ColorPalette && h.palette == nil {
+		return nil, nil, damaged(chunkSection(chunkPalette), errors.New("missing PLTE chunk"))
+	}
+	if h.tiled() {
+		if h.tileSizes == nil {
+			return nil, nil, damaged(chunkSection(chunkIndex), errors.New("missing INDX chunk"))
+		}
+		return h, tileSource(h, c, limits), nil
+	}
+
+	data := &dataReader{c: c}
+	decompressor := newDecompressor(data, h.codec)
+	finish := func() error {
+		if err := drain(decompressor); err != nil {
+			return err
+		}
+		// Uncompressed streams end with the last DATA chunk, so the
+		// drain may already have moved on to the following chunk.
+		if !data.done {
+			if c.remaining != 0 {
+				return damaged(SectionPixels, errors.New("trailing pixel data"))
+			}
+			if err := c.end(); err != nil {
+				return err
+			}
+			if err := c.next(); err != nil {
+				return err
+			}
+		}
+		return h.readTrailer(c, limits)
+	}
+	return h, streamSource(h, &pixelStream{Reader: decompressor, finish: finish}), nil
+}
+
+// drain reads to the end of a decompressed pixel stream, so checksums
+// cover all of it. Any pixel data beyond the image is an error, which also
+// stops a crafted stream from inflating without bound here.
+func drain(decompressor io.Reader) error {
+	if n, err := io.Copy(io.Discard, io.LimitReader(decompressor, 1)); err != nil {
+		return damaged(SectionPixels, err)
+	} else if n != 0 {
+		return damaged(SectionPixels, errors.New("trailing pixel data"))
+	}
+	return nil
+}
+
+// readChunk handles a chunk other than DATA and END!, skipping unknown
+// ancillary chunks.
+func (h *header) readChunk(c *chunkReader, limits *Limits) error {
+	switch {
+	case h.keepFrames && isFrameChunk(c.typ):
+		return h.readFrameChunk(c, limits)
+	case c.typ == chunkMetadata:
+		if err := limits.checkMetadata(c.remaining); err != nil {
+			return err
+		}
+		data, err := c.body()
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &h.metadata); err != nil {
+			return damaged(SectionMetadata, err)
+		}
+		return nil
+	case c.typ == chunkHead:
+		return damaged(SectionHeader, errors.New("duplicate HEAD chunk"))
+	case isCritical(c.typ):
+		// A damaged type is more likely than a newer encoder, so the CRC
+		// gets the first word.
+		if err := c.end(); err != nil {
+			return err
+		}
+		return fmt.Errorf("unsupported critical HUH chunk %q", c.typ)
+	}
+	return c.end()
+}
+
+// readTrailer handles the chunks after the pixel data, starting with the
+// one c has just read, up to and including END!.
+func (h *header) readTrailer(c *chunkReader, limits *Limits) error {
+	for {
+		switch c.typ {
+		case chunkEnd:
+			if err := c.end(); err != nil {
+				return err
+			}
+			return h.checkFrames()
+		case chunkData:
+			return damaged(SectionPixels, errors.New("unexpected DATA chunk"))
+		}
+		if err := h.readChunk(c, limits); err != nil {
+			return err
+		}
+		if err := c.next(); err != nil {
+			return err
+		}
+	}
+}
+
+// streamSource reads the rows of an untiled file from a single stream.
+func streamSource(h *header, ps *pixelStream) pixelSource {
+	return func(d *Decoder, fn rowFunc) error {
+		f := h.format()
+		bpp := f.bytesPerPixel()
+		rowLen := int(h.width) * bpp
+		raw := make([]byte, rowLen+1)
+		prev := make([]byte, rowLen)
+		if h.filter == filterMethodNone {
+			raw = raw[:rowLen]
+		}
+		for y := 0; y < int(h.height); y++ {
+			if _, err := io.ReadFull(ps, raw); err != nil {
+				var se *SectionError
+				if !errors.As(err, &se) {
+					err = damaged(SectionPixels, err)
+				}
+				return err
+			}
+			row := raw
+			if h.filter == filterMethodAdaptive {
+				row = raw[1:]
+				if err := unfilter(raw[0], row, prev, bpp); err != nil {
+					return damaged(SectionPixels, err)
+				}
+				copy(prev, row)
+			}
+			if err := f.checkRow(row); err != nil {
+				return damaged(SectionPixels, err)
+			}
+			fn(y, 0, row)
+			if d.Progress != nil {
+				d.Progress(float32(y+1) / float32(h.height))
+			}
+		}
+		return ps.finish()
+	}
+}
+
+// Decode reads a HUH image and its metadata from r. Files larger than the
+// Decoder's limits fail with an error wrapping ErrTooLarge, damaged files
+// with one matching ErrCorrupt. RGB files decode to an *image.RGBA and RGBA
+// files to an *image.NRGBA, or *image.RGBA64 and *image.NRGBA64 for 16-bit
+// files. Gray files decode to an *image.Gray or *image.Gray16, and palette
+// files to an *image.Paletted. The image bounds start at the origin recorded
+// in the file, or at (0, 0) if there is none.
+func (d *Decoder) Decode(r io.Reader) (image.Image, Metadata, error) {
+	h, src, err := d.open(r, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, fn := h.newImage(h.bounds())
+	if err := src(d, fn); err != nil {
+		return nil, nil, err
+	}
+	return img, h.metadata, nil
+}
+
+// bounds returns the rectangle covered by the image of h.
+func (h *header) bounds() image.Rectangle {
+	return image.Rectangle{Min: h.origin, Max: h.origin.Add(image.Pt(int(h.width), int(h.height)))}
+}
+
+// newImage allocates an image for the part of h inside rect and returns
+// the rowFunc that fills it. Pixels outside rect are dropped.
+func (h *header) newImage(rect image.Rectangle) (image.Image, rowFunc) {
+	f := h.format()
+	img, pix, stride := f.newImage(rect)
+
+	// Rows come relative to the origin of the file.
+	clip := rect.Sub(h.origin)
+	bpp, dbpp := f.bytesPerPixel(), f.decodedBytesPerPixel()
+	return img, func(y, x0 int, row []byte) {
+		x1 := x0 + len(row)/bpp
+		if y < clip.Min.Y || y >= clip.Max.Y || x1 <= clip.Min.X || x0 >= clip.Max.X {
+			return
+		}
+		lo, hi := max(x0, clip.Min.X), min(x1, clip.Max.X)
+		row = row[(lo-x0)*bpp : (hi-x0)*bpp]
+		writeRow(f, pix[(y-clip.Min.Y)*stride+(lo-clip.Min.X)*dbpp:], row)
+	}
+}
+
+// DecodeConfig returns the dimensions, color model and metadata of a HUH
+// image without decompressing its pixels.
+func (d *Decoder) DecodeConfig(r io.Reader) (image.Config, Metadata, error) {
+	h, _, err := d.open(r, false)
+	if err != nil {
+		return image.Config{}, nil, err
+	}
+	return image.Config{ColorModel: h.format().colorModel(), Width: int(h.width), Height: int(h.height)}, h.metadata, nil
+}
+
+// Verify reads a whole HUH file from r and checks every section without
+// building an image. Damaged sections are reported as a *SectionError.
+// v2 files have no checksums, so only truncation and undecodable data can
+// be detected in them.
+func (d *Decoder) Verify(r io.Reader) error {
+	h, src, err := d.open(r, true)
+	if err != nil {
+		return err
+	}
+	if err := src(d, func(int, int, []byte) {}); err != nil {
+		return err
+	}
+	return h.decodeFrames(func(int, *header) rowFunc {
+		return func(int, int, []byte) {}
+	})
+}
+
+// Decode reads a HUH image from r. Use a Decoder to also get its metadata.
+func Decode(r io.Reader) (image.Image, error) {
+	img, _, err := new(Decoder).Decode(r)
+	return img, err
+}
+
+// DecodeConfig returns the color model and dimensions of a HUH image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	cfg, _, err := new(Decoder).DecodeConfig(r)
+	return cfg, err
+}
+
+// Verify checks the integrity of the HUH file read from r.
+func Verify(r io.Reader) error {
+	return new(Decoder).Verify(r)
+}
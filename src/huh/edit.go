@@ -0,0 +1,220 @@
+package huh
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EditMetadata copies the HUH file in r to w with its metadata changed by
+// edit, which may modify the map it is given in place. Every other chunk,
+// including the compressed pixel data, is copied byte for byte after its
+// CRC has been checked, so nothing is recompressed. v2 files are written
+// as v3 files around their original DEFLATE stream.
+func (d *Decoder) EditMetadata(w io.Writer, r io.Reader, edit func(Metadata) error) error {
+	br := bufio.NewReader(r)
+	prefix := make([]byte, len(Magic)+1)
+	if _, err := io.ReadFull(br, prefix); err != nil || string(prefix[:len(Magic)]) != Magic {
+		return errors.New("invalid HUH file: bad magic number")
+	}
+	switch version := prefix[len(Magic)]; {
+	case version == 2:
+		return d.editLegacy(w, br, edit)
+	case version != Version:
+		return fmt.Errorf("unsupported HUH version: %d", version)
+	}
+
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	c := newChunkReader(br)
+	if err := c.next(); err != nil {
+		return err
+	}
+	if c.typ != chunkHead {
+		return damaged(SectionHeader, errors.New("missing HEAD chunk"))
+	}
+	written := false
+	for {
+		switch {
+		case c.typ == chunkMetadata:
+			if written {
+				return damaged(SectionMetadata, errors.New("duplicate metadata chunk"))
+			}
+			if err := d.limits().checkMetadata(c.remaining); err != nil {
+				return err
+			}
+			data, err := c.body()
+			if err != nil {
+				return err
+			}
+			var meta Metadata
+			if err := meta.UnmarshalJSON(data); err != nil {
+				return damaged(SectionMetadata, err)
+			}
+			if err := writeMetadata(w, meta, edit); err != nil {
+				return err
+			}
+			written = true
+		case c.typ != chunkHead && c.typ != chunkPalette && !written:
+			// The file has no metadata chunk yet; it goes where the
+			// encoder would have put it.
+			if err := writeMetadata(w, nil, edit); err != nil {
+				return err
+			}
+			written = true
+			continue
+		default:
+			if err := copyChunk(w, c); err != nil {
+				return err
+			}
+			if c.typ == chunkEnd {
+				return nil
+			}
+		}
+		if err := c.next(); err != nil {
+			return err
+		}
+	}
+}
+
+// writeMetadata applies edit to meta and writes the result as a meta
+// chunk.
+func writeMetadata(w io.Writer, meta Metadata, edit func(Metadata) error) error {
+	if meta == nil {
+		meta = Metadata{}
+	}
+	if err := edit(meta); err != nil {
+		return err
+	}
+	data, err := meta.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return writeChunk(w, chunkMetadata, data)
+}
+
+// copyChunk copies the chunk c has just reached to w, checking its CRC on
+// the way.
+func copyChunk(w io.Writer, c *chunkReader) error {
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[:4], c.remaining)
+	copy(hdr[4:], c.typ)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, c); err != nil {
+		return damaged(chunkSection(c.typ), err)
+	}
+	if err := c.end(); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, c.crc.Sum32())
+}
+
+// editLegacy rewrites a v2 file as v3. Its pixels are an unfiltered
+// DEFLATE stream, which a v3 file without tiles can hold as is, so the
+// stream is copied into DATA chunks while being inflated to find its end
+// and check it.
+func (d *Decoder) editLegacy(w io.Writer, br *bufio.Reader, edit func(Metadata) error) error {
+	h, _, err := openLegacy(br, d.limits())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, Magic); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{Version}); err != nil {
+		return err
+	}
+	head := make([]byte, 29)
+	binary.LittleEndian.PutUint32(head[0:], h.width)
+	binary.LittleEndian.PutUint32(head[4:], h.height)
+	binary.LittleEndian.PutUint32(head[8:], uint32(int32(h.origin.X)))
+	binary.LittleEndian.PutUint32(head[12:], uint32(int32(h.origin.Y)))
+	head[16] = byte(h.colorType)
+	head[17] = filterMethodNone
+	head[18] = byte(CompressionDeflate)
+	head[28] = 8
+	if err := writeChunk(w, chunkHead, head); err != nil {
+		return err
+	}
+	if err := writeMetadata(w, h.metadata, edit); err != nil {
+		return err
+	}
+
+	data := &chunkWriter{w: w, typ: chunkData}
+	decompressor := flate.NewReader(&teeReader{r: br, w: data})
+	size := int64(h.height) * int64(h.width) * int64(h.format().bytesPerPixel())
+	if _, err := io.CopyN(io.Discard, decompressor, size); err != nil {
+		return damaged(SectionPixels, err)
+	}
+	if err := drain(decompressor); err != nil {
+		return err
+	}
+	if data.err != nil {
+		return data.err
+	}
+	if err := data.flush(); err != nil {
+		return err
+	}
+	return writeChunk(w, chunkEnd, nil)
+}
+
+// teeReader writes every byte read from r to w. It implements
+// io.ByteReader so flate does not read past the end of the stream.
+type teeReader struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.w.Write(p[:n])
+	return n, err
+}
+
+func (t *teeReader) ReadByte() (byte, error) {
+	b, err := t.r.ReadByte()
+	if err == nil {
+		t.w.Write([]byte{b})
+	}
+	return b, err
+}
+
+// chunkWriter splits what is written to it into chunks of typ of up to
+// 64 KiB. Write errors are kept in err, since its writers ignore them.
+type chunkWriter struct {
+	w   io.Writer
+	typ string
+	buf []byte
+	err error
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for len(c.buf) >= 64<<10 && c.err == nil {
+		c.err = writeChunk(c.w, c.typ, c.buf[:64<<10])
+		c.buf = c.buf[64<<10:]
+	}
+	return len(p), nil
+}
+
+// flush writes what is left as a last, shorter chunk.
+func (c *chunkWriter) flush() error {
+	if c.err == nil && len(c.buf) > 0 {
+		c.err = writeChunk(c.w, c.typ, c.buf)
+		c.buf = nil
+	}
+	return c.err
+}
+
+// EditMetadata copies the HUH file in r to w with its metadata changed by
+// edit.
+func EditMetadata(w io.Writer, r io.Reader, edit func(Metadata) error) error {
+	return new(Decoder).EditMetadata(w, r, edit)
+}
@@ -0,0 +1,160 @@
+package huh
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"image"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// chunksExcept returns the bytes of every chunk of file but those of type
+// skip, in order.
+func chunksExcept(t *testing.T, file []byte, skip string) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for _, c := range splitChunks(t, file) {
+		if c.typ != skip {
+			out = append(out, file[c.at:c.at+c.size])
+		}
+	}
+	return out
+}
+
+func TestEditMetadata(t *testing.T) {
+	img := testImage(image.Rect(0, 0, 40, 30))
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Metadata: Metadata{KeyAuthor: "Jane", KeyTitle: "Old"}, TileSize: 16, Thumbnail: 10}); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	edit := func(meta Metadata) error {
+		delete(meta, KeyAuthor)
+		meta[KeyTitle] = "New"
+		meta["iso"] = 200
+		return nil
+	}
+	want := Metadata{KeyTitle: "New", "iso": int64(200)}
+
+	var out bytes.Buffer
+	if err := EditMetadata(&out, bytes.NewReader(file), edit); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(chunksExcept(t, out.Bytes(), chunkMetadata), chunksExcept(t, file, chunkMetadata)) {
+		t.Error("chunks other than the metadata changed")
+	}
+	got, meta, err := new(Decoder).Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameImage(t, "edited", got, img)
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("metadata %v, want %v", meta, want)
+	}
+
+	// A file without a meta chunk gets one where the encoder puts it.
+	m := locateChunk(t, file, chunkMetadata)
+	bare := append(bytes.Clone(file[:m.at]), file[m.at+m.size:]...)
+	out.Reset()
+	if err := EditMetadata(&out, bytes.NewReader(bare), edit); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes()[:m.at], file[:m.at]) || locateChunk(t, out.Bytes(), chunkMetadata).at != m.at {
+		t.Error("metadata added in the wrong place")
+	}
+	if _, meta, err = new(Decoder).DecodeConfig(bytes.NewReader(out.Bytes())); err != nil || !reflect.DeepEqual(meta, Metadata{KeyTitle: "New", "iso": int64(200)}) {
+		t.Errorf("added metadata %v, %v", meta, err)
+	}
+
+	errEdit := errors.New("edit failed")
+	if err := EditMetadata(&out, bytes.NewReader(file), func(Metadata) error { return errEdit }); err != errEdit {
+		t.Errorf("failing edit: got %v", err)
+	}
+	data := locateChunk(t, file, chunkData)
+	damagedFile := bytes.Clone(file)
+	damagedFile[data.at+data.size-1] ^= 0xff
+	if err := EditMetadata(&out, bytes.NewReader(damagedFile), edit); !errors.Is(err, ErrChecksum) {
+		t.Errorf("damaged DATA chunk: got %v, want ErrChecksum", err)
+	}
+}
+
+func TestEditMetadataVersion2(t *testing.T) {
+	img := opaqueImage(image.Rect(0, 0, 300, 200))
+	var buf bytes.Buffer
+	buf.Write(v2Header(2, 300, 200))
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	for i := 0; i < len(img.Pix); i += 4 {
+		fw.Write(img.Pix[i : i+3])
+	}
+	fw.Close()
+	stream := buf.Bytes()[len(v2Header(2, 300, 200)):]
+
+	when := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var out bytes.Buffer
+	err := EditMetadata(&out, bytes.NewReader(buf.Bytes()), func(meta Metadata) error {
+		meta[KeyCreationDate] = when
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := out.Bytes()
+	if file[len(Magic)] != Version {
+		t.Fatalf("edited file has version %d", file[len(Magic)])
+	}
+	// The DEFLATE stream is kept, split over DATA chunks.
+	var pixels []byte
+	for _, c := range splitChunks(t, file) {
+		if c.typ == chunkData {
+			pixels = append(pixels, file[c.at+8:c.at+c.size-4]...)
+		}
+	}
+	if !bytes.Equal(pixels, stream) {
+		t.Error("DEFLATE stream changed")
+	}
+	got, meta, err := new(Decoder).Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameImage(t, "upgraded", got, img)
+	if !reflect.DeepEqual(meta, Metadata{KeyCreationDate: when}) {
+		t.Errorf("metadata %v", meta)
+	}
+	if err := Verify(bytes.NewReader(file)); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		key, typ, s string
+		want        any
+	}{
+		{"note", "", "42", "42"},
+		{"iso", "int", "200", int64(200)},
+		{"exposure", "float", "2", 2.0},
+		{"flash", "bool", "true", true},
+		{KeyCreationDate, "", "2024-05-01T00:00:00Z", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{KeyKeywords, "", `["beach", "2024"]`, []any{"beach", "2024"}},
+		{"lens", "json", `{"focal_length": 50}`, Metadata{"focal_length": int64(50)}},
+	}
+	for _, tt := range tests {
+		got, err := ParseValue(tt.key, tt.typ, tt.s)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseValue(%q, %q, %q) = %#v, %v, want %#v", tt.key, tt.typ, tt.s, got, err, tt.want)
+		}
+	}
+	for _, bad := range [][3]string{
+		{"iso", "int", "many"},
+		{"note", "color", "red"},
+		{"note", "json", "{"},
+		{KeyAuthor, "int", "3"},
+		{KeyCreationDate, "", "yesterday"},
+	} {
+		if v, err := ParseValue(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("ParseValue(%q, %q, %q) = %#v", bad[0], bad[1], bad[2], v)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package huh
+
+import (
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"huh/src/internal/imageutil"
+)
+
+// Encode writes img to w in HUH format. A nil opts is equivalent to an
+// empty Options.
+func Encode(w io.Writer, img image.Image, opts *Options) error {
+	return encode(w, img, opts, nil)
+}
+
+// formatOf picks the pixel format img is stored with. depth is the bit
+// depth, or zero to keep the depth of img.
+func formatOf(img image.Image, colorType *ColorType, depth int) (pixelFormat, error) {
+	f := pixelFormat{depth: depth}
+	if colorType != nil {
+		f.colorType = *colorType
+	} else {
+		f.colorType = colorTypeOf(img)
+		if f.colorType == ColorPalette && depth == 16 {
+			// Palette indices are 8-bit only.
+			f.colorType = ColorRGB
+			if !imageutil.Opaque(img) {
+				f.colorType = ColorRGBA
+			}
+		}
+	}
+	if f.colorType > ColorPalette {
+		return f, fmt.Errorf("unsupported HUH color type: %v", f.colorType)
+	}
+	if f.depth == 0 {
+		f.depth = depthOf(img)
+		if f.colorType == ColorPalette {
+			f.depth = 8
+		}
+	}
+	if f.depth != 8 && f.depth != 16 {
+		return f, fmt.Errorf("invalid HUH bit depth: %d", depth)
+	}
+	if f.colorType == ColorPalette {
+		if f.depth != 8 {
+			return f, fmt.Errorf("invalid HUH bit depth for a palette: %d", depth)
+		}
+		var err error
+		if f.palette, f.index, err = paletteOf(img); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// encode writes img as a HUH file and, if anim is non-nil, the frames of
+// anim after its first, which img stands for.
+func encode(w io.Writer, img image.Image, opts *Options, anim *Animation) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	metadata := opts.Metadata
+	if metadata == nil {
+		metadata = Metadata{}
+	}
+
+	level := opts.Level
+	if level == 0 {
+		level = flate.BestCompression
+	}
+	if level < flate.BestSpeed || level > flate.BestCompression {
+		return fmt.Errorf("invalid HUH compression level: %d", opts.Level)
+	}
+	if opts.Compression > CompressionLZ {
+		return fmt.Errorf("unsupported HUH compression: %v", opts.Compression)
+	}
+	if opts.Compression != CompressionDeflate {
+		level = 0
+	}
+
+	quality := opts.Quality
+	if quality < 0 || quality > 100 {
+		return fmt.Errorf("invalid HUH quality: %d", opts.Quality)
+	}
+	if anim != nil {
+		quality = 0
+	}
+	colorType, depth := opts.ColorType, opts.BitDepth
+	if quality > 0 {
+		if colorType == nil && colorTypeOf(img) == ColorPalette {
+			rgb := ColorRGB
+			if !imageutil.Opaque(img) {
+				rgb = ColorRGBA
+			}
+			colorType = &rgb
+		}
+		if colorType != nil && *colorType == ColorPalette {
+			return errors.New("lossy HUH encoding does not support palette images")
+		}
+		if depth == 16 {
+			return errors.New("lossy HUH encoding only supports 8-bit samples")
+		}
+		depth = 8
+	}
+	format, err := formatOf(img, colorType, depth)
+	if err != nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	width, height := uint32(bounds.Dx()), uint32(bounds.Dy())
+	var origin image.Point
+	if opts.KeepOrigin {
+		origin = bounds.Min
+	}
+	tileSize := opts.TileSize
+	if tileSize == 0 {
+		tileSize = DefaultTileSize
+	}
+	if tileSize < 0 {
+		return fmt.Errorf("invalid HUH tile size: %d", opts.TileSize)
+	}
+	grid := tileGrid{width: int(width), height: int(height), tileW: tileSize, tileH: tileSize}
+	if grid.count() > maxTiles {
+		return fmt.Errorf("HUH tile size %d is too small for a %dx%d image", tileSize, width, height)
+	}
+
+	if _, err := io.WriteString(w, Magic); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{Version}); err != nil {
+		return err
+	}
+
+	head := make([]byte, 30)
+	binary.LittleEndian.PutUint32(head[0:], width)
+	binary.LittleEndian.PutUint32(head[4:], height)
+	binary.LittleEndian.PutUint32(head[8:], uint32(int32(origin.X)))
+	binary.LittleEndian.PutUint32(head[12:], uint32(int32(origin.Y)))
+	head[16] = byte(format.colorType)
+	head[17] = filterMethodAdaptive
+	if quality > 0 {
+		head[17] = filterMethodLossy
+	}
+	head[18] = byte(opts.Compression)
+	head[19] = byte(level)
+	binary.LittleEndian.PutUint32(head[20:], uint32(tileSize))
+	binary.LittleEndian.PutUint32(head[24:], uint32(tileSize))
+	head[28] = byte(format.depth)
+	head[29] = byte(quality)
+	if err := writeChunk(w, chunkHead, head); err != nil {
+		return err
+	}
+	if format.colorType == ColorPalette {
+		if err := writeChunk(w, chunkPalette, encodePalette(format.palette)); err != nil {
+			return err
+		}
+	}
+
+	metadataJSON, err := metadata.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if err := writeChunk(w, chunkMetadata, metadataJSON); err != nil {
+		return err
+	}
+	if opts.ICCProfile != nil {
+		profile, err := encodeICCProfile(opts.ICCProfile)
+		if err != nil {
+			return err
+		}
+		if err := writeChunk(w, chunkICCProfile, profile); err != nil {
+			return err
+		}
+	}
+	if opts.Thumbnail > 0 {
+		thumb, err := encodeThumbnail(img, opts)
+		if err != nil {
+			return err
+		}
+		if thumb != nil {
+			if err := writeChunk(w, chunkThumbnail, thumb); err != nil {
+				return err
+			}
+		}
+	}
+
+	progress := opts.Progress
+	if anim != nil {
+		if err := writeAnimation(w, anim, bounds, format.colorType); err != nil {
+			return err
+		}
+		// Every frame counts the same towards the progress.
+		if progress != nil {
+			progress = func(p float32) { opts.Progress(p / float32(len(anim.Image))) }
+		}
+	}
+
+	tiles, err := encodeTiles(img, grid, format, opts.Compression, level, quality, concurrency(opts.Concurrency), progress)
+	if err != nil {
+		return err
+	}
+	index := make([]byte, 0, 4*len(tiles))
+	for _, tile := range tiles {
+		index = binary.LittleEndian.AppendUint32(index, uint32(len(tile)))
+	}
+	if err := writeChunk(w, chunkIndex, index); err != nil {
+		return err
+	}
+	for _, tile := range tiles {
+		if err := writeChunk(w, chunkData, tile); err != nil {
+			return err
+		}
+	}
+
+	if anim != nil {
+		for i := 1; i < len(anim.Image); i++ {
+			if err := writeFrame(w, anim, i, bounds.Min, format.depth, opts, level); err != nil {
+				return err
+			}
+			if opts.Progress != nil {
+				opts.Progress(float32(i+1) / float32(len(anim.Image)))
+			}
+		}
+	}
+	return writeChunk(w, chunkEnd, nil)
+}
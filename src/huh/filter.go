@@ -0,0 +1,127 @@
+package huh
+
+import (
+	"errors"
+)
+
+// Filter methods recorded in the HEAD chunk. With filterAdaptive every row
+// of the pixel stream is preceded by the filter type that was applied to
+// it, as in PNG.
+const (
+	filterMethodNone     = 0
+	filterMethodAdaptive = 1
+)
+
+// Filter types for a single row. They predict each byte from its left,
+// upper and upper left neighbors and store the difference.
+const (
+	filterNone = iota
+	filterSub
+	filterUp
+	filterAverage
+	filterPaeth
+	numFilters
+)
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// rowFilter picks a filter type for every row, using the usual PNG
+// heuristic of the smallest sum of absolute signed differences.
+type rowFilter struct {
+	bpp  int
+	prev []byte
+	cand [numFilters][]byte
+}
+
+func newRowFilter(bpp, rowLen int) *rowFilter {
+	f := &rowFilter{bpp: bpp, prev: make([]byte, rowLen)}
+	for i := range f.cand {
+		f.cand[i] = make([]byte, 1+rowLen)
+		f.cand[i][0] = byte(i)
+	}
+	return f
+}
+
+// filter returns cur prefixed with its filter type and filtered. The
+// result is only valid until the next call.
+func (f *rowFilter) filter(cur []byte) []byte {
+	bpp, prev := f.bpp, f.prev
+	none, sub, up, avg, pth := f.cand[filterNone][1:], f.cand[filterSub][1:], f.cand[filterUp][1:], f.cand[filterAverage][1:], f.cand[filterPaeth][1:]
+	for i, x := range cur {
+		var a, c uint8
+		if i >= bpp {
+			a, c = cur[i-bpp], prev[i-bpp]
+		}
+		b := prev[i]
+		none[i] = x
+		sub[i] = x - a
+		up[i] = x - b
+		avg[i] = x - uint8((int(a)+int(b))/2)
+		pth[i] = x - paeth(a, b, c)
+	}
+
+	best, bestSum := 0, -1
+	for i, cand := range f.cand {
+		sum := 0
+		for _, v := range cand[1:] {
+			sum += abs(int(int8(v)))
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = i, sum
+		}
+	}
+	copy(f.prev, cur)
+	return f.cand[best]
+}
+
+// unfilter reverses the filter ft applied to cur in place, given the
+// previous reconstructed row.
+func unfilter(ft byte, cur, prev []byte, bpp int) error {
+	switch ft {
+	case filterNone:
+	case filterSub:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case filterUp:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case filterAverage:
+		for i := range cur {
+			var a uint8
+			if i >= bpp {
+				a = cur[i-bpp]
+			}
+			cur[i] += uint8((int(a) + int(prev[i])) / 2)
+		}
+	case filterPaeth:
+		for i := range cur {
+			var a, c uint8
+			if i >= bpp {
+				a, c = cur[i-bpp], prev[i-bpp]
+			}
+			cur[i] += paeth(a, prev[i], c)
+		}
+	default:
+		return errors.New("bad row filter type")
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package huh
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// corpus lists the sample images the README measures the filters on.
+var corpus = []string{
+	"blue-purple-pink-large.png",
+	"branches.png",
+	"colormap.png",
+	"flowers.png",
+	"go-turns-two-280x360.jpeg",
+	"tux.png",
+	"video-001.png",
+	"yellow_rose.png",
+}
+
+// corpusDirs returns the directories to look for the corpus in: those of
+// $HUH_CORPUS, separated like $PATH, or else the testdata directories of
+// the modules that ship the images.
+func corpusDirs(t *testing.T) []string {
+	if dirs := os.Getenv("HUH_CORPUS"); dirs != "" {
+		return filepath.SplitList(dirs)
+	}
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "golang.org/x/image", "github.com/disintegration/imaging").Output()
+	if err != nil {
+		t.Skipf("sample corpus not found, set HUH_CORPUS: %v", err)
+	}
+	var dirs []string
+	for _, dir := range strings.Fields(string(out)) {
+		dirs = append(dirs, filepath.Join(dir, "testdata"))
+	}
+	return dirs
+}
+
+func loadCorpusImage(t *testing.T, dirs []string, name string) image.Image {
+	for _, dir := range dirs {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		img, _, err := image.Decode(f)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return img
+	}
+	t.Skipf("sample image %s not found, set HUH_CORPUS", name)
+	return nil
+}
+
+// unfilteredSize is the size of the tiles of img compressed like Encode
+// does, but with the rows stored as they are.
+func unfilteredSize(t *testing.T, img image.Image) int {
+	f, err := formatOf(img, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+	g := tileGrid{width: b.Dx(), height: b.Dy(), tileW: DefaultTileSize, tileH: DefaultTileSize}
+	size := 0
+	for i := range g.count() {
+		r := g.rect(i)
+		var buf bytes.Buffer
+		fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+		row := make([]byte, r.Dx()*f.bytesPerPixel())
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			readRow(img, f, b.Min.X+r.Min.X, b.Min.Y+y, row)
+			fw.Write(row)
+		}
+		if err := fw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		size += buf.Len()
+	}
+	return size
+}
+
+// TestFilterGain reports the size of the pixel data of the sample corpus
+// with and without the row filters, as listed in the README:
+//
+//	go test ./src/huh -run FilterGain -v
+func TestFilterGain(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping corpus encoding in short mode")
+	}
+	dirs := corpusDirs(t)
+	var report strings.Builder
+	report.WriteString("| File | Unfiltered | Filtered | Change |\n|------|-----------:|---------:|-------:|\n")
+	var totalRaw, totalFiltered int
+	for _, name := range corpus {
+		img := loadCorpusImage(t, dirs, name)
+		raw := unfilteredSize(t, img)
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, nil); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// Every DATA chunk holds one filtered and compressed tile.
+		filtered := 0
+		for _, c := range splitChunks(t, buf.Bytes()) {
+			if c.typ == chunkData {
+				filtered += c.size - 12
+			}
+		}
+		totalRaw += raw
+		totalFiltered += filtered
+		fmt.Fprintf(&report, "| `%s` | %s | %s | %+.1f%% |\n", name, commas(raw), commas(filtered), change(raw, filtered))
+	}
+	fmt.Fprintf(&report, "| **Total** | %s | %s | %+.1f%% |", commas(totalRaw), commas(totalFiltered), change(totalRaw, totalFiltered))
+	t.Log("\n" + report.String())
+}
+
+func change(from, to int) float64 {
+	return 100 * float64(to-from) / float64(from)
+}
+
+// commas formats n with thousands separators, as the README tables do.
+func commas(n int) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+// Package huh implements the HUH image format.
+//
+// A HUH file starts with the "HUH!" magic number and a version byte. Since
+// v3 the rest of the file is a sequence of chunks, each with a length, a
+// four letter type and a CRC32, in the spirit of PNG: a HEAD chunk with the
+// dimensions, color type, bit depth, codec, tile size and lossy quality, a
+// PLTE chunk for palette images, an optional JSON metadata chunk, optional
+// ICC profile and thumbnail chunks, an INDX chunk with the size of every
+// tile, one DATA chunk per compressed tile, and an END! chunk. Files using
+// the fixed v2 layout can still be decoded. Importing this package
+// registers the format with the standard library, so image.Decode
+// understands HUH files.
+package huh
+
+import (
+	"fmt"
+	"image"
+)
+
+const (
+	// Magic is the signature every HUH file starts with.
+	Magic = "HUH!"
+	// Version is the format version written by Encode.
+	Version = 3
+)
+
+// ColorType describes how pixels are laid out in the compressed stream.
+type ColorType uint8
+
+const (
+	ColorRGB  ColorType = 0
+	ColorRGBA ColorType = 1
+	// ColorGray stores one luminance sample per pixel.
+	ColorGray ColorType = 2
+	// ColorPalette stores one 8-bit index per pixel into the palette held
+	// in the PLTE chunk.
+	ColorPalette ColorType = 3
+)
+
+func (c ColorType) String() string {
+	switch c {
+	case ColorRGB:
+		return "rgb"
+	case ColorRGBA:
+		return "rgba"
+	case ColorGray:
+		return "gray"
+	case ColorPalette:
+		return "palette"
+	}
+	return fmt.Sprintf("ColorType(%d)", uint8(c))
+}
+
+func (c ColorType) channels() int {
+	switch c {
+	case ColorRGBA:
+		return 4
+	case ColorRGB:
+		return 3
+	}
+	return 1
+}
+
+// Options are the encoding parameters.
+type Options struct {
+	// Metadata is written to the file header. It may be nil.
+	Metadata Metadata
+	// KeepOrigin records img.Bounds().Min in the file so the decoder
+	// rebuilds an image with the same bounds. By default the decoded image
+	// starts at (0, 0).
+	KeepOrigin bool
+	// ColorType, if non-nil, forces the color type of the file, converting
+	// the pixels as needed. By default *image.Paletted images are stored
+	// with a palette, *image.Gray and *image.Gray16 as gray, images with
+	// transparency as RGBA and everything else as RGB.
+	ColorType *ColorType
+	// BitDepth is the number of bits per channel, 8 or 16. Zero means 16
+	// for images with 16-bit channels, such as *image.RGBA64 or 16-bit
+	// PNGs, and 8 otherwise.
+	BitDepth int
+	// Compression is the codec for the pixel data.
+	Compression Compression
+	// Level is the DEFLATE level from 1 (fastest) to 9 (smallest). Zero
+	// means 9.
+	Level int
+	// Quality, from 1 to 100, stores the image lossily, trading accuracy
+	// for size much like JPEG quality does: colors are kept as YCbCr with
+	// the chroma at half resolution, and quantized more coarsely the lower
+	// the quality. Zero means lossless. Lossy files hold 8-bit RGB, RGBA
+	// or gray samples, so palette images are stored as RGB or RGBA, and
+	// animations are always lossless.
+	Quality int
+	// TileSize is the width and height of the independently compressed
+	// tiles. Zero means DefaultTileSize.
+	TileSize int
+	// Concurrency is the number of tiles compressed at once. Zero means
+	// runtime.GOMAXPROCS(0).
+	Concurrency int
+	// ICCProfile, if non-nil, is stored as the color profile of the image.
+	ICCProfile []byte
+	// Thumbnail, if positive, embeds a preview of the image scaled down to
+	// fit in a Thumbnail×Thumbnail square, which DecodeThumbnail reads
+	// without the full pixel data. Images that already fit get none.
+	Thumbnail int
+	// Progress, if non-nil, is called with values in [0, 1] while pixels
+	// are being compressed.
+	Progress func(float32)
+}
+
+func init() {
+	image.RegisterFormat("huh", Magic, Decode, DecodeConfig)
+}
@@ -0,0 +1,296 @@
+package huh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// testImage returns an NRGBA image with the given bounds whose pixels
+// depend on their position, with some transparency.
+func testImage(r image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 13), uint8(x ^ y), uint8(128 + x + y)})
+		}
+	}
+	return img
+}
+
+// opaqueImage returns testImage without its transparency.
+func opaqueImage(r image.Rectangle) *image.NRGBA {
+	img := testImage(r)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	return img
+}
+
+// sameImage fails t unless got has the bounds and the pixels of want.
+func sameImage(t *testing.T, name string, got, want image.Image) {
+	t.Helper()
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("%s: bounds %v, want %v", name, got.Bounds(), want.Bounds())
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.NRGBA64Model.Convert(got.At(x, y))
+			w := color.NRGBA64Model.Convert(want.At(x, y))
+			if g != w {
+				t.Fatalf("%s: pixel (%d, %d) is %v, want %v", name, x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := image.Rect(0, 0, 23, 17)
+	tests := []struct {
+		name  string
+		img   image.Image
+		model color.Model
+	}{
+		{"opaque", opaqueImage(r), color.RGBAModel},
+		{"alpha", testImage(r), color.NRGBAModel},
+	}
+	meta := Metadata{"author": "Jane Doe", "title": "Test"}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tt.img, &Options{Metadata: meta}); err != nil {
+			t.Fatalf("%s: Encode: %v", tt.name, err)
+		}
+		cfg, gotMeta, err := new(Decoder).DecodeConfig(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: DecodeConfig: %v", tt.name, err)
+		}
+		if cfg.ColorModel != tt.model || cfg.Width != r.Dx() || cfg.Height != r.Dy() {
+			t.Errorf("%s: DecodeConfig gives %+v", tt.name, cfg)
+		}
+		if !reflect.DeepEqual(gotMeta, meta) {
+			t.Errorf("%s: metadata %v, want %v", tt.name, gotMeta, meta)
+		}
+		got, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", tt.name, err)
+		}
+		if got.ColorModel() != tt.model {
+			t.Errorf("%s: color model %v, want %v", tt.name, got.ColorModel(), tt.model)
+		}
+		sameImage(t, tt.name, got, tt.img)
+	}
+}
+
+func TestOffsetBounds(t *testing.T) {
+	big := testImage(image.Rect(0, 0, 40, 30))
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"subimage", big.SubImage(image.Rect(7, 5, 31, 22))},
+		{"negative origin", testImage(image.Rect(-12, -9, 13, 8))},
+		{"positive origin", testImage(image.Rect(100, 200, 117, 219))},
+	}
+	for _, tt := range tests {
+		for _, keep := range []bool{false, true} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, tt.img, &Options{KeepOrigin: keep, TileSize: 8}); err != nil {
+				t.Fatalf("%s: Encode: %v", tt.name, err)
+			}
+			got, err := Decode(&buf)
+			if err != nil {
+				t.Fatalf("%s: Decode: %v", tt.name, err)
+			}
+			src := tt.img.Bounds()
+			want := src.Sub(src.Min)
+			if keep {
+				want = src
+			}
+			if got.Bounds() != want {
+				t.Errorf("%s, KeepOrigin %v: bounds %v, want %v", tt.name, keep, got.Bounds(), want)
+				continue
+			}
+			offset := want.Min.Sub(src.Min)
+			for y := src.Min.Y; y < src.Max.Y; y++ {
+				for x := src.Min.X; x < src.Max.X; x++ {
+					w := color.NRGBAModel.Convert(tt.img.At(x, y))
+					g := color.NRGBAModel.Convert(got.At(x+offset.X, y+offset.Y))
+					if g != w {
+						t.Fatalf("%s, KeepOrigin %v: pixel (%d, %d) is %v, want %v", tt.name, keep, x, y, g, w)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDepth16(t *testing.T) {
+	r := image.Rect(0, 0, 19, 11)
+	alpha := image.NewNRGBA64(r)
+	gray := image.NewGray16(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			// Low bytes that differ from the high ones are lost at 8 bits.
+			alpha.SetNRGBA64(x, y, color.NRGBA64{uint16(x * 3001), uint16(y * 5003), uint16(x*y + 1), uint16(30000 + x*y*97)})
+			gray.SetGray16(x, y, color.Gray16{uint16(x*2999 + y*7)})
+		}
+	}
+	opaque := image.NewRGBA64(r)
+	draw.Draw(opaque, r, alpha, r.Min, draw.Src)
+	for i := 6; i < len(opaque.Pix); i += 8 {
+		opaque.Pix[i], opaque.Pix[i+1] = 0xff, 0xff
+	}
+	tests := []struct {
+		name  string
+		img   image.Image
+		model color.Model
+	}{
+		{"RGBA64", opaque, color.RGBA64Model},
+		{"NRGBA64", alpha, color.NRGBA64Model},
+		{"Gray16", gray, color.Gray16Model},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tt.img, nil); err != nil {
+			t.Fatalf("%s: Encode: %v", tt.name, err)
+		}
+		got, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", tt.name, err)
+		}
+		if got.ColorModel() != tt.model {
+			t.Errorf("%s: color model %v, want %v", tt.name, got.ColorModel(), tt.model)
+		}
+		sameImage(t, tt.name, got, tt.img)
+	}
+
+	// BitDepth overrides the depth of the source image.
+	var buf bytes.Buffer
+	if err := Encode(&buf, alpha, &Options{BitDepth: 8}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := image.NewNRGBA(r)
+	draw.Draw(want, r, alpha, r.Min, draw.Src)
+	sameImage(t, "BitDepth 8", got, want)
+
+	src := testImage(r)
+	buf.Reset()
+	if err := Encode(&buf, src, &Options{BitDepth: 16}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got.ColorModel() != color.NRGBA64Model {
+		t.Errorf("BitDepth 16: color model %v, want NRGBA64", got.ColorModel())
+	}
+	sameImage(t, "BitDepth 16", got, src)
+
+	if err := Encode(io.Discard, src, &Options{BitDepth: 12}); err == nil {
+		t.Error("BitDepth 12 accepted")
+	}
+}
+
+func TestColorTypes(t *testing.T) {
+	r := image.Rect(0, 0, 21, 13)
+	src := testImage(r)
+	gray := image.NewGray(r)
+	draw.Draw(gray, r, src, r.Min, draw.Src)
+	palette := color.Palette{color.NRGBA{}, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 128}, color.NRGBA{255, 255, 255, 255}}
+	paletted := image.NewPaletted(r, palette)
+	few := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			paletted.SetColorIndex(x, y, uint8(x*y)%4)
+			few.Set(x, y, palette[(x+y)%4])
+		}
+	}
+	colorType := func(c ColorType) *ColorType { return &c }
+	tests := []struct {
+		name      string
+		img       image.Image
+		colorType *ColorType
+		model     color.Model
+		want      image.Image
+	}{
+		{"Gray", gray, nil, color.GrayModel, gray},
+		{"Paletted", paletted, nil, palette, paletted},
+		{"forced palette", few, colorType(ColorPalette), nil, few},
+		{"forced gray", src, colorType(ColorGray), color.GrayModel, gray},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tt.img, &Options{ColorType: tt.colorType}); err != nil {
+			t.Fatalf("%s: Encode: %v", tt.name, err)
+		}
+		got, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", tt.name, err)
+		}
+		switch want := tt.model.(type) {
+		case nil:
+			if _, ok := got.ColorModel().(color.Palette); !ok {
+				t.Errorf("%s: color model %T, want a palette", tt.name, got.ColorModel())
+			}
+		case color.Palette:
+			if !reflect.DeepEqual(got.ColorModel(), want) {
+				t.Errorf("%s: palette %v, want %v", tt.name, got.ColorModel(), want)
+			}
+		default:
+			if got.ColorModel() != want {
+				t.Errorf("%s: color model %v, want %v", tt.name, got.ColorModel(), want)
+			}
+		}
+		sameImage(t, tt.name, got, tt.want)
+	}
+
+	if err := Encode(io.Discard, src, &Options{ColorType: colorType(ColorPalette)}); err == nil {
+		t.Error("palette with more than 256 colors accepted")
+	}
+	if err := Encode(io.Discard, paletted, &Options{ColorType: colorType(ColorPalette), BitDepth: 16}); err == nil {
+		t.Error("16-bit palette accepted")
+	}
+}
+
+func TestForgedPaletteLength(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(buf.Bytes(), []byte(chunkPalette))
+	if i < 4 {
+		t.Fatal("no PLTE chunk")
+	}
+	for _, length := range []uint32{0, 6, 4*256 + 4, 1<<32 - 4} {
+		file := bytes.Clone(buf.Bytes())
+		binary.LittleEndian.PutUint32(file[i-4:], length)
+		for name, fn := range map[string]func() error{
+			"Decode": func() error { _, err := Decode(bytes.NewReader(file)); return err },
+			"Verify": func() error { return Verify(bytes.NewReader(file)) },
+		} {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			err := fn()
+			runtime.ReadMemStats(&after)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("%s, PLTE length %d: got %v, want ErrCorrupt", name, length, err)
+			}
+			if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+				t.Errorf("%s, PLTE length %d: allocated %d bytes", name, length, n)
+			}
+		}
+	}
+}
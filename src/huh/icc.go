@@ -0,0 +1,58 @@
+package huh
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// The iccp chunk holds the ICC color profile of the image, compressed with
+// DEFLATE. Like the thumbnail it precedes the pixel data.
+const chunkICCProfile = "iccp"
+
+func encodeICCProfile(profile []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(profile); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeICCProfile reads the ICC color profile embedded in a HUH file,
+// without touching its pixel data. It returns nil if the file has none.
+// Profiles count towards the Decoder's MaxMetadataSize.
+func (d *Decoder) DecodeICCProfile(r io.Reader) ([]byte, error) {
+	c, err := findChunk(r, chunkICCProfile)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	limit := int64(d.limits().MaxMetadataSize)
+	if limit <= 0 {
+		limit = 1<<63 - 2
+	}
+	profile, err := io.ReadAll(io.LimitReader(flate.NewReader(c), limit+1))
+	if err != nil {
+		return nil, damaged(chunkSection(chunkICCProfile), err)
+	}
+	if int64(len(profile)) > limit {
+		return nil, fmt.Errorf("%w: ICC profile exceeds %d bytes", ErrTooLarge, limit)
+	}
+	if err := c.end(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// DecodeICCProfile reads the ICC color profile embedded in a HUH file
+// from r.
+func DecodeICCProfile(r io.Reader) ([]byte, error) {
+	return new(Decoder).DecodeICCProfile(r)
+}
@@ -0,0 +1,43 @@
+package huh
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestICCProfile(t *testing.T) {
+	profile := bytes.Repeat([]byte("fake ICC profile "), 100)
+	img := testImage(image.Rect(0, 0, 8, 8))
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{ICCProfile: profile}); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	// The profile precedes the pixel data, which is not read.
+	iccp := locateChunk(t, file, chunkICCProfile)
+	got, err := DecodeICCProfile(bytes.NewReader(file[:iccp.at+iccp.size]))
+	if err != nil || !bytes.Equal(got, profile) {
+		t.Errorf("got %d bytes, %v, want the %d byte profile", len(got), err, len(profile))
+	}
+	decoded, err := Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameImage(t, "with profile", decoded, img)
+
+	small := &Decoder{Limits: &Limits{MaxMetadataSize: 100}}
+	if _, err := small.DecodeICCProfile(bytes.NewReader(file)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("oversized profile: got %v, want ErrTooLarge", err)
+	}
+
+	buf.Reset()
+	if err := Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecodeICCProfile(&buf); got != nil || err != nil {
+		t.Errorf("file without profile: got %d bytes, %v", len(got), err)
+	}
+}
@@ -0,0 +1,45 @@
+package huh
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+)
+
+// openLegacy reads the fixed layout of v2 files, whose magic number and
+// version byte have already been read. v2 files have no color type, origin
+// or checksums: they are always RGB and start at (0, 0).
+func openLegacy(br *bufio.Reader, limits *Limits) (*header, *pixelStream, error) {
+	h := &header{colorType: ColorRGB, depth: 8}
+
+	var metaLen uint32
+	if err := binary.Read(br, binary.LittleEndian, &metaLen); err != nil {
+		return nil, nil, damaged(SectionHeader, err)
+	}
+	if err := limits.checkMetadata(metaLen); err != nil {
+		return nil, nil, err
+	}
+	metaJSON := make([]byte, metaLen)
+	if _, err := io.ReadFull(br, metaJSON); err != nil {
+		return nil, nil, damaged(SectionMetadata, err)
+	}
+
+	if err := binary.Read(br, binary.LittleEndian, &h.width); err != nil {
+		return nil, nil, damaged(SectionHeader, err)
+	}
+	if err := binary.Read(br, binary.LittleEndian, &h.height); err != nil {
+		return nil, nil, damaged(SectionHeader, err)
+	}
+
+	if err := h.check(limits); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(metaJSON, &h.metadata); err != nil {
+		return nil, nil, damaged(SectionMetadata, err)
+	}
+
+	finish := func() error { return nil }
+	return h, &pixelStream{Reader: flate.NewReader(br), finish: finish}, nil
+}
@@ -0,0 +1,36 @@
+package huh
+
+import (
+	"bytes"
+	"compress/flate"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestVersion2(t *testing.T) {
+	img := opaqueImage(image.Rect(0, 0, 13, 7))
+	var buf bytes.Buffer
+	buf.Write(v2Header(2, 13, 7))
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	for i := 0; i < len(img.Pix); i += 4 {
+		fw.Write(img.Pix[i : i+3])
+	}
+	fw.Close()
+	file := buf.Bytes()
+
+	got, meta, err := new(Decoder).Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ColorModel() != color.RGBAModel || len(meta) != 0 {
+		t.Errorf("got %v and metadata %v, want RGBA without metadata", got.ColorModel(), meta)
+	}
+	sameImage(t, "v2", got, img)
+	if err := Verify(bytes.NewReader(file)); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if _, err := Decode(bytes.NewReader(file[:len(file)-3])); err == nil {
+		t.Error("truncated: no error")
+	}
+}
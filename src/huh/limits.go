@@ -0,0 +1,58 @@
+package huh
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTooLarge is wrapped by errors for files that exceed the decoder's
+	// Limits.
+	ErrTooLarge = errors.New("HUH file exceeds decoder limits")
+	// ErrCorrupt matches every error caused by damaged or inconsistent data,
+	// including all *SectionError values.
+	ErrCorrupt = errors.New("corrupt HUH file")
+)
+
+// Limits bound the resources a Decoder will commit to a single file, so a
+// hostile header cannot exhaust memory. A zero field means no limit.
+type Limits struct {
+	// MaxWidth and MaxHeight bound the image dimensions in pixels.
+	MaxWidth  int
+	MaxHeight int
+	// MaxMetadataSize bounds the size of the metadata block in bytes.
+	MaxMetadataSize int
+	// MaxDecodedBytes bounds the memory used by the decoded image, or by
+	// all frames of an animation.
+	MaxDecodedBytes int64
+}
+
+// DefaultLimits are used by a Decoder whose Limits field is nil.
+var DefaultLimits = Limits{
+	MaxWidth:        1 << 16,
+	MaxHeight:       1 << 16,
+	MaxMetadataSize: 4 << 20,
+	MaxDecodedBytes: 1 << 30,
+}
+
+func (l *Limits) checkMetadata(size uint32) error {
+	if l.MaxMetadataSize > 0 && uint64(size) > uint64(l.MaxMetadataSize) {
+		return fmt.Errorf("%w: %d bytes of metadata, limit is %d", ErrTooLarge, size, l.MaxMetadataSize)
+	}
+	return nil
+}
+
+func (l *Limits) checkImage(width, height uint32, bytesPerPixel int) error {
+	if (l.MaxWidth > 0 && uint64(width) > uint64(l.MaxWidth)) || (l.MaxHeight > 0 && uint64(height) > uint64(l.MaxHeight)) {
+		return fmt.Errorf("%w: %dx%d image, limit is %dx%d", ErrTooLarge, width, height, l.MaxWidth, l.MaxHeight)
+	}
+	// Computed in uint64 so that no uint32 dimensions can overflow.
+	return l.checkDecoded(uint64(width) * uint64(height) * uint64(bytesPerPixel))
+}
+
+func (l *Limits) checkDecoded(decoded uint64) error {
+	if l.MaxDecodedBytes > 0 && decoded > uint64(l.MaxDecodedBytes) {
+		return fmt.Errorf("%w: %d decoded bytes, limit is %d", ErrTooLarge, decoded, l.MaxDecodedBytes)
+	}
+	return nil
+}
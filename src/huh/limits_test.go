@@ -0,0 +1,77 @@
+package huh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"runtime"
+	"testing"
+)
+
+// v2Header returns the header of a v2 file, which has no checksum that a
+// forged size would have to match.
+func v2Header(metaLen, width, height uint32) []byte {
+	head := append([]byte(Magic), 2)
+	head = binary.LittleEndian.AppendUint32(head, metaLen)
+	if metaLen == 2 {
+		head = append(head, "{}"...)
+	}
+	head = binary.LittleEndian.AppendUint32(head, width)
+	return binary.LittleEndian.AppendUint32(head, height)
+}
+
+func TestLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, testImage(image.Rect(0, 0, 31, 19)), &Options{Metadata: Metadata{"title": "limits"}}); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	metaSize := len(`{"title":"limits"}`)
+	tests := []struct {
+		limits   Limits
+		tooLarge bool
+	}{
+		{Limits{}, false},
+		{Limits{MaxWidth: 31, MaxHeight: 19, MaxMetadataSize: metaSize, MaxDecodedBytes: 31 * 19 * 4}, false},
+		{Limits{MaxWidth: 30}, true},
+		{Limits{MaxHeight: 18}, true},
+		{Limits{MaxMetadataSize: metaSize - 1}, true},
+		{Limits{MaxDecodedBytes: 31*19*4 - 1}, true},
+	}
+	for _, tt := range tests {
+		dec := &Decoder{Limits: &tt.limits}
+		_, _, err := dec.Decode(bytes.NewReader(file))
+		if tt.tooLarge != errors.Is(err, ErrTooLarge) {
+			t.Errorf("%+v: Decode: %v", tt.limits, err)
+		}
+		_, _, err = dec.DecodeConfig(bytes.NewReader(file))
+		if tt.tooLarge != errors.Is(err, ErrTooLarge) {
+			t.Errorf("%+v: DecodeConfig: %v", tt.limits, err)
+		}
+	}
+
+	// Hostile headers are refused by the default limits before anything
+	// is allocated for them.
+	for _, head := range [][]byte{
+		v2Header(2, 1<<20, 1),
+		v2Header(2, 1, 1<<20),
+		v2Header(2, 1<<16, 1<<16),
+		v2Header(1<<31, 1, 1),
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := Decode(bytes.NewReader(head))
+		runtime.ReadMemStats(&after)
+		if !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%x: got %v, want ErrTooLarge", head, err)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("%x: allocated %d bytes", head, n)
+		}
+	}
+
+	if _, err := Decode(bytes.NewReader(file[:len(file)/2])); !errors.Is(err, ErrCorrupt) || errors.Is(err, ErrTooLarge) {
+		t.Errorf("truncated file: got %v, want ErrCorrupt", err)
+	}
+}
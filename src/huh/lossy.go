@@ -0,0 +1,259 @@
+package huh
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"huh/src/internal/imageutil"
+)
+
+// With filterMethodLossy every tile holds planes instead of pixel rows:
+// luma, then for RGB and RGBA the Cb and Cr planes at half the width and
+// height, then for RGBA the alpha plane. Colors are converted as in JPEG,
+// and the chroma of each 2×2 block is averaged. Each plane row starts
+// with a filter type, as in the lossless layout, but the rest holds
+// prediction residuals divided by a quantization step derived from the
+// quality in HEAD, each an int8. The encoder predicts from the pixels the
+// decoder will reconstruct, so the error stays within half a step instead
+// of adding up along the row, and gradients do not turn into bands. Alpha
+// is always kept exact.
+const filterMethodLossy = 2
+
+// quantSteps returns the quantization steps of luma and chroma for a
+// quality from 1 to 100, scaled like the JPEG quantization tables.
+func quantSteps(quality int) (luma, chroma int) {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	return min(max(1, (8*scale+50)/100), 64), min(max(1, (12*scale+50)/100), 96)
+}
+
+// predict returns the value filter type ft predicts from the left, upper
+// and upper left neighbors a, b and c.
+func predict(ft int, a, b, c uint8) int {
+	switch ft {
+	case filterSub:
+		return int(a)
+	case filterUp:
+		return int(b)
+	case filterAverage:
+		return (int(a) + int(b)) / 2
+	case filterPaeth:
+		return int(paeth(a, b, c))
+	}
+	return 0
+}
+
+// reconstruct returns the sample coded as residual q from pred. A step of
+// 1 stores exact residuals, which wrap around like the lossless filters.
+func reconstruct(pred int, q byte, step int) uint8 {
+	if step == 1 {
+		return uint8(pred) + q
+	}
+	return uint8(min(max(pred+int(int8(q))*step, 0), 255))
+}
+
+// quantizer codes the rows of one plane.
+type quantizer struct {
+	step int
+	prev []byte
+	cand [numFilters][]byte
+	rec  [numFilters][]byte
+}
+
+func newQuantizer(step, rowLen int) *quantizer {
+	q := &quantizer{step: step, prev: make([]byte, rowLen)}
+	for i := range q.cand {
+		q.cand[i] = make([]byte, 1+rowLen)
+		q.cand[i][0] = byte(i)
+		q.rec[i] = make([]byte, rowLen)
+	}
+	return q
+}
+
+// code returns cur coded with every filter type in turn and keeps the one
+// with the smallest residuals, as rowFilter does. The result is only valid
+// until the next call.
+func (q *quantizer) code(cur []byte) []byte {
+	best, bestSum := 0, -1
+	for ft := range q.cand {
+		out, rec := q.cand[ft][1:], q.rec[ft]
+		sum := 0
+		for i, x := range cur {
+			var a, c uint8
+			if i > 0 {
+				a, c = rec[i-1], q.prev[i-1]
+			}
+			pred := predict(ft, a, q.prev[i], c)
+			d := int(x) - pred
+			if q.step > 1 {
+				// Round to the nearest step, halves away from zero.
+				if d >= 0 {
+					d = (d + q.step/2) / q.step
+				} else {
+					d = -((-d + q.step/2) / q.step)
+				}
+				d = min(max(d, -128), 127)
+			}
+			out[i] = byte(d)
+			rec[i] = reconstruct(pred, out[i], q.step)
+			sum += abs(int(int8(out[i])))
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = ft, sum
+		}
+	}
+	copy(q.prev, q.rec[best])
+	return q.cand[best]
+}
+
+// dequantize turns the residuals in cur, coded with filter type ft, back
+// into samples in place, given the previous reconstructed row.
+func dequantize(ft byte, cur, prev []byte, step int) error {
+	if ft >= numFilters {
+		return errors.New("bad row filter type")
+	}
+	for i := range cur {
+		var a, c uint8
+		if i > 0 {
+			a, c = cur[i-1], prev[i-1]
+		}
+		cur[i] = reconstruct(predict(int(ft), a, prev[i], c), cur[i], step)
+	}
+	return nil
+}
+
+// tilePlanes are the planes of one lossy tile, with the steps they are
+// quantized with.
+type tilePlanes struct {
+	w, h   int
+	planes [][]byte
+	steps  []int
+}
+
+func newTilePlanes(colorType ColorType, w, h, quality int) *tilePlanes {
+	luma, chroma := quantSteps(quality)
+	p := &tilePlanes{w: w, h: h}
+	p.add(w*h, luma)
+	if colorType != ColorGray {
+		cw, ch := (w+1)/2, (h+1)/2
+		p.add(cw*ch, chroma)
+		p.add(cw*ch, chroma)
+	}
+	if colorType == ColorRGBA {
+		p.add(w*h, 1)
+	}
+	return p
+}
+
+func (p *tilePlanes) add(size, step int) {
+	p.planes = append(p.planes, make([]byte, size))
+	p.steps = append(p.steps, step)
+}
+
+// size returns the width and height of plane i.
+func (p *tilePlanes) size(i int) (int, int) {
+	if i == 1 || i == 2 {
+		return (p.w + 1) / 2, (p.h + 1) / 2
+	}
+	return p.w, p.h
+}
+
+// encodeLossy writes tile r of e.img to w in the lossy layout.
+func (e *tileEncoder) encodeLossy(w io.Writer, r image.Rectangle) error {
+	f := e.format
+	p := newTilePlanes(f.colorType, r.Dx(), r.Dy(), e.quality)
+	bpp := f.bytesPerPixel()
+	row := make([]byte, r.Dx()*bpp)
+	origin := e.img.Bounds().Min
+	cw, _ := p.size(1)
+	// The chroma planes first hold the sums of their 2×2 blocks.
+	var sums [2][]int
+	if f.colorType != ColorGray {
+		sums = [2][]int{make([]int, len(p.planes[1])), make([]int, len(p.planes[2]))}
+	}
+	for y := 0; y < p.h; y++ {
+		readRow(e.img, f, origin.X+r.Min.X, origin.Y+r.Min.Y+y, row)
+		for x := 0; x < p.w; x++ {
+			px := row[x*bpp:]
+			if f.colorType == ColorGray {
+				p.planes[0][y*p.w+x] = px[0]
+				continue
+			}
+			yy, cb, cr := color.RGBToYCbCr(px[0], px[1], px[2])
+			p.planes[0][y*p.w+x] = yy
+			sums[0][y/2*cw+x/2] += int(cb)
+			sums[1][y/2*cw+x/2] += int(cr)
+			if f.colorType == ColorRGBA {
+				p.planes[3][y*p.w+x] = px[3]
+			}
+		}
+	}
+	for c, s := range sums {
+		for i, sum := range s {
+			x, y := i%cw, i/cw
+			n := (min(2*x+2, p.w) - 2*x) * (min(2*y+2, p.h) - 2*y)
+			p.planes[1+c][i] = uint8((sum + n/2) / n)
+		}
+	}
+
+	for i, plane := range p.planes {
+		pw, ph := p.size(i)
+		q := newQuantizer(p.steps[i], pw)
+		for y := 0; y < ph; y++ {
+			if _, err := w.Write(q.code(plane[y*pw : (y+1)*pw])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeLossy reads tile r of a lossy file from src and passes its rows,
+// converted back to the pixel format of h, to fn.
+func decodeLossy(h *header, r image.Rectangle, src io.Reader, fn rowFunc) error {
+	f := h.format()
+	if f.colorType == ColorPalette || f.depth != 8 {
+		return fmt.Errorf("lossy HUH data cannot hold %v pixels", f.colorType)
+	}
+	p := newTilePlanes(f.colorType, r.Dx(), r.Dy(), int(h.quality))
+	for i, plane := range p.planes {
+		pw, ph := p.size(i)
+		raw := make([]byte, 1+pw)
+		prev := make([]byte, pw)
+		for y := 0; y < ph; y++ {
+			if _, err := io.ReadFull(src, raw); err != nil {
+				return imageutil.NoEOF(err)
+			}
+			if err := dequantize(raw[0], raw[1:], prev, p.steps[i]); err != nil {
+				return err
+			}
+			copy(prev, raw[1:])
+			copy(plane[y*pw:], raw[1:])
+		}
+	}
+
+	bpp := f.bytesPerPixel()
+	row := make([]byte, p.w*bpp)
+	cw, _ := p.size(1)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			px := row[x*bpp:]
+			yy := p.planes[0][y*p.w+x]
+			if f.colorType == ColorGray {
+				px[0] = yy
+				continue
+			}
+			px[0], px[1], px[2] = color.YCbCrToRGB(yy, p.planes[1][y/2*cw+x/2], p.planes[2][y/2*cw+x/2])
+			if f.colorType == ColorRGBA {
+				px[3] = p.planes[3][y*p.w+x]
+			}
+		}
+		fn(r.Min.Y+y, r.Min.X, row)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package huh
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// lossyTestImage returns a noisy gradient with odd dimensions, so tiles and
+// chroma blocks are cut at the edges, with varying alpha if alpha is set.
+func lossyTestImage(alpha bool) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 37, 29))
+	for y := 0; y < 29; y++ {
+		for x := 0; x < 37; x++ {
+			c := color.NRGBA{uint8(x*6 + rng.Intn(20)), uint8(y*8 + rng.Intn(20)), uint8(rng.Intn(256)), 255}
+			if alpha {
+				c.A = uint8(rng.Intn(256))
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func lossyRoundTrip(t *testing.T, img image.Image, quality int) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Quality: quality, TileSize: 16}); err != nil {
+		t.Fatalf("quality %d: Encode: %v", quality, err)
+	}
+	got, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("quality %d: Decode: %v", quality, err)
+	}
+	if got.Bounds() != img.Bounds() {
+		t.Fatalf("quality %d: bounds %v, want %v", quality, got.Bounds(), img.Bounds())
+	}
+	return got
+}
+
+// subsampled returns img as the lossy layout holds it with exact planes:
+// converted to YCbCr with the chroma of every 2×2 block averaged.
+func subsampled(img *image.NRGBA) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			var cbSum, crSum, n int
+			for by := y &^ 1; by < min(y&^1+2, b.Dy()); by++ {
+				for bx := x &^ 1; bx < min(x&^1+2, b.Dx()); bx++ {
+					c := img.NRGBAAt(bx, by)
+					_, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+					cbSum, crSum, n = cbSum+int(cb), crSum+int(cr), n+1
+				}
+			}
+			c := img.NRGBAAt(x, y)
+			yy, _, _ := color.RGBToYCbCr(c.R, c.G, c.B)
+			r, g, bl := color.YCbCrToRGB(yy, uint8((cbSum+n/2)/n), uint8((crSum+n/2)/n))
+			out.SetNRGBA(x, y, color.NRGBA{r, g, bl, c.A})
+		}
+	}
+	return out
+}
+
+func absDiff(a, b uint8) int {
+	return abs(int(a) - int(b))
+}
+
+func TestLossyQuality100(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 37, 29))
+	rand.New(rand.NewSource(2)).Read(gray.Pix)
+	got := lossyRoundTrip(t, gray, 100)
+	for y := 0; y < 29; y++ {
+		for x := 0; x < 37; x++ {
+			if g, w := color.GrayModel.Convert(got.At(x, y)), gray.GrayAt(x, y); g != w {
+				t.Fatalf("gray pixel (%d, %d) is %v, want %v", x, y, g, w)
+			}
+		}
+	}
+
+	// Colors only lose what the conversion to YCbCr and the chroma
+	// subsampling lose; every plane is exact.
+	for _, alpha := range []bool{false, true} {
+		img := lossyTestImage(alpha)
+		want := subsampled(img)
+		got := lossyRoundTrip(t, img, 100)
+		for y := 0; y < 29; y++ {
+			for x := 0; x < 37; x++ {
+				if g, w := color.NRGBAModel.Convert(got.At(x, y)), want.NRGBAAt(x, y); g != w {
+					t.Fatalf("alpha %v: pixel (%d, %d) is %v, want %v", alpha, x, y, g, w)
+				}
+			}
+		}
+	}
+}
+
+func TestLossyError(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 37, 29))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i%37*5 + i/37*2)
+	}
+	img := lossyTestImage(true)
+	want := subsampled(img)
+
+	for _, quality := range []int{1, 10, 25, 50, 75, 90, 99} {
+		luma, chroma := quantSteps(quality)
+
+		got := lossyRoundTrip(t, gray, quality)
+		for y := 0; y < 29; y++ {
+			for x := 0; x < 37; x++ {
+				g := color.GrayModel.Convert(got.At(x, y)).(color.Gray)
+				if d := absDiff(g.Y, gray.GrayAt(x, y).Y); d > luma/2 {
+					t.Fatalf("quality %d: gray pixel (%d, %d) is off by %d, step %d", quality, x, y, d, luma)
+				}
+			}
+		}
+
+		// The luma and chroma errors of half a step carry over to each RGB
+		// channel with the weights of the YCbCr conversion, plus rounding.
+		limits := [3]float64{
+			float64(luma)/2 + 1.402*float64(chroma)/2 + 2,
+			float64(luma)/2 + (0.344+0.714)*float64(chroma)/2 + 2,
+			float64(luma)/2 + 1.772*float64(chroma)/2 + 2,
+		}
+		got = lossyRoundTrip(t, img, quality)
+		for y := 0; y < 29; y++ {
+			for x := 0; x < 37; x++ {
+				g, w := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA), want.NRGBAAt(x, y)
+				if g.A != w.A {
+					t.Fatalf("quality %d: alpha at (%d, %d) is %d, want %d", quality, x, y, g.A, w.A)
+				}
+				for c, d := range [3]int{absDiff(g.R, w.R), absDiff(g.G, w.G), absDiff(g.B, w.B)} {
+					if float64(d) > limits[c] {
+						t.Fatalf("quality %d: channel %d at (%d, %d) is off by %d, limit %.1f", quality, c, x, y, d, limits[c])
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestQuantizerError checks every plane sample of every quality against
+// half its step, which the image tests only see through the conversion.
+func TestQuantizerError(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	const w, h = 53, 20
+	plane := make([]byte, w*h)
+	for i := range plane {
+		// Smooth areas, noise and hard edges.
+		switch i / w % 3 {
+		case 0:
+			plane[i] = uint8(i % w * 4)
+		case 1:
+			plane[i] = uint8(rng.Intn(256))
+		default:
+			plane[i] = uint8(255 * (i / 7 % 2))
+		}
+	}
+	for quality := 1; quality <= 100; quality++ {
+		luma, chroma := quantSteps(quality)
+		if quality == 100 && (luma != 1 || chroma != 1) {
+			t.Fatalf("quality 100 has steps %d and %d, want 1", luma, chroma)
+		}
+		for _, step := range []int{luma, chroma} {
+			q := newQuantizer(step, w)
+			prev := make([]byte, w)
+			for y := 0; y < h; y++ {
+				src := plane[y*w : (y+1)*w]
+				coded := bytes.Clone(q.code(src))
+				if err := dequantize(coded[0], coded[1:], prev, step); err != nil {
+					t.Fatal(err)
+				}
+				for x, v := range coded[1:] {
+					if d := absDiff(v, src[x]); d > step/2 {
+						t.Fatalf("quality %d, step %d: sample (%d, %d) is off by %d", quality, step, x, y, d)
+					}
+				}
+				copy(prev, coded[1:])
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package huh
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"huh/src/internal/imageutil"
+)
+
+// The LZ codec is a small LZ77 variant in the style of LZ4, trading
+// compression ratio for speed. The stream is a sequence of independent
+// blocks, each preceded by its decompressed and compressed length as
+// uint32s. A compressed length of zero means the block is stored as is,
+// and a decompressed length of zero ends the stream.
+//
+// Within a block, every sequence starts with a token byte holding the
+// number of literals in its high nibble and the match length minus
+// lzMinMatch in its low nibble. A nibble of 15 is followed by extra length
+// bytes, each added to it, until one is not 255. The literals come next,
+// then the match offset as a little-endian uint16. The last sequence of a
+// block has literals only.
+const (
+	lzBlockSize = 1 << 16
+	lzMinMatch  = 4
+	lzHashLog   = 14
+)
+
+var errLZCorrupt = errors.New("corrupt LZ block")
+
+type lzWriter struct {
+	w     io.Writer
+	buf   []byte
+	out   []byte
+	table [1 << lzHashLog]int32
+}
+
+func newLZWriter(w io.Writer) *lzWriter {
+	return &lzWriter{w: w, buf: make([]byte, 0, lzBlockSize)}
+}
+
+func (z *lzWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		m := min(len(p), lzBlockSize-len(z.buf))
+		z.buf = append(z.buf, p[:m]...)
+		p = p[m:]
+		if len(z.buf) == lzBlockSize {
+			if err := z.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close flushes the last block and writes the end of stream marker.
+func (z *lzWriter) Close() error {
+	if err := z.flush(); err != nil {
+		return err
+	}
+	var end [8]byte
+	_, err := z.w.Write(end[:])
+	return err
+}
+
+func (z *lzWriter) flush() error {
+	if len(z.buf) == 0 {
+		return nil
+	}
+	z.out = lzCompressBlock(z.out[:0], z.buf, &z.table)
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[:4], uint32(len(z.buf)))
+	body := z.out
+	if len(z.out) >= len(z.buf) {
+		body = z.buf
+	} else {
+		binary.LittleEndian.PutUint32(hdr[4:], uint32(len(z.out)))
+	}
+	z.buf = z.buf[:0]
+	if _, err := z.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := z.w.Write(body)
+	return err
+}
+
+func lzHash(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - lzHashLog)
+}
+
+func lzPutLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// lzCompressBlock appends the compressed form of src to dst.
+func lzCompressBlock(dst, src []byte, table *[1 << lzHashLog]int32) []byte {
+	for i := range table {
+		table[i] = -1
+	}
+	anchor := 0
+	for i := 0; i+lzMinMatch <= len(src); {
+		h := lzHash(binary.LittleEndian.Uint32(src[i:]))
+		cand := int(table[h])
+		table[h] = int32(i)
+		if cand < 0 || i-cand > 0xffff || binary.LittleEndian.Uint32(src[cand:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+		matchLen := lzMinMatch
+		for i+matchLen < len(src) && src[cand+matchLen] == src[i+matchLen] {
+			matchLen++
+		}
+		dst = lzSequence(dst, src[anchor:i], matchLen, i-cand)
+		i += matchLen
+		anchor = i
+	}
+	return lzSequence(dst, src[anchor:], 0, 0)
+}
+
+// lzSequence appends one sequence. A zero offset marks the final,
+// literals-only sequence.
+func lzSequence(dst, literals []byte, matchLen, offset int) []byte {
+	litNibble := min(len(literals), 15)
+	matchNibble := 0
+	if offset != 0 {
+		matchNibble = min(matchLen-lzMinMatch, 15)
+	}
+	dst = append(dst, byte(litNibble<<4|matchNibble))
+	if litNibble == 15 {
+		dst = lzPutLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if offset == 0 {
+		return dst
+	}
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(offset))
+	if matchNibble == 15 {
+		dst = lzPutLength(dst, matchLen-lzMinMatch-15)
+	}
+	return dst
+}
+
+type lzReader struct {
+	r      io.Reader
+	in     []byte
+	out    []byte
+	pos    int
+	closed bool
+}
+
+func newLZReader(r io.Reader) *lzReader {
+	return &lzReader{r: r}
+}
+
+func (z *lzReader) Read(p []byte) (int, error) {
+	for z.pos == len(z.out) {
+		if z.closed {
+			return 0, io.EOF
+		}
+		if err := z.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, z.out[z.pos:])
+	z.pos += n
+	return n, nil
+}
+
+func (z *lzReader) readBlock() error {
+	var hdr [8]byte
+	if _, err := io.ReadFull(z.r, hdr[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	rawLen := binary.LittleEndian.Uint32(hdr[:4])
+	packedLen := binary.LittleEndian.Uint32(hdr[4:])
+	if rawLen == 0 {
+		z.closed = true
+		return nil
+	}
+	if rawLen > lzBlockSize || packedLen > lzBlockSize {
+		return errLZCorrupt
+	}
+	z.out, z.pos = z.out[:0], 0
+	if packedLen == 0 {
+		z.out = append(z.out, make([]byte, rawLen)...)
+		_, err := io.ReadFull(z.r, z.out)
+		return imageutil.NoEOF(err)
+	}
+	z.in = append(z.in[:0], make([]byte, packedLen)...)
+	if _, err := io.ReadFull(z.r, z.in); err != nil {
+		return imageutil.NoEOF(err)
+	}
+	out, err := lzDecompressBlock(z.out, z.in, int(rawLen))
+	z.out = out
+	return err
+}
+
+// lzGetLength reads the extra length bytes that follow a nibble of 15.
+func lzGetLength(src []byte, i int) (int, int, error) {
+	n := 0
+	for {
+		if i >= len(src) {
+			return 0, 0, errLZCorrupt
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, nil
+		}
+	}
+}
+
+// lzDecompressBlock appends the decompressed form of src, which must be
+// exactly rawLen bytes long, to dst.
+func lzDecompressBlock(dst, src []byte, rawLen int) ([]byte, error) {
+	start := len(dst)
+	for i := 0; ; {
+		if i >= len(src) {
+			return nil, errLZCorrupt
+		}
+		token := src[i]
+		i++
+		litLen := int(token >> 4)
+		if litLen == 15 {
+			extra, next, err := lzGetLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			litLen, i = litLen+extra, next
+		}
+		if litLen > len(src)-i || len(dst)-start+litLen > rawLen {
+			return nil, errLZCorrupt
+		}
+		dst = append(dst, src[i:i+litLen]...)
+		i += litLen
+		if i == len(src) {
+			if len(dst)-start != rawLen {
+				return nil, errLZCorrupt
+			}
+			return dst, nil
+		}
+
+		if i+2 > len(src) {
+			return nil, errLZCorrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		matchLen := int(token&15) + lzMinMatch
+		if token&15 == 15 {
+			extra, next, err := lzGetLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			matchLen, i = matchLen+extra, next
+		}
+		if offset == 0 || offset > len(dst)-start || len(dst)-start+matchLen > rawLen {
+			return nil, errLZCorrupt
+		}
+		// Byte by byte, since a match may overlap the bytes it produces.
+		from := len(dst) - offset
+		for k := 0; k < matchLen; k++ {
+			dst = append(dst, dst[from+k])
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package huh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func lzRoundTrip(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	z := newLZWriter(&buf)
+	// Odd write sizes so blocks are filled across several writes.
+	for p := data; len(p) > 0; {
+		n := min(len(p), 10007)
+		if _, err := z.Write(p[:n]); err != nil {
+			t.Fatalf("%s: Write: %v", name, err)
+		}
+		p = p[n:]
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("%s: Close: %v", name, err)
+	}
+	packed := buf.Bytes()
+	got, err := io.ReadAll(newLZReader(bytes.NewReader(packed)))
+	if err != nil {
+		t.Fatalf("%s: Read: %v", name, err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("%s: round trip changed the data", name)
+	}
+	return packed
+}
+
+func TestLZRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 3*lzBlockSize+123)
+	rng.Read(random)
+
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog, "), 5000)
+	// A run of one byte and a short repeating pattern are coded as
+	// matches that overlap the bytes they produce.
+	run := bytes.Repeat([]byte{7}, 5000)
+	pattern := bytes.Repeat([]byte("abc"), 3000)
+
+	tests := []struct {
+		name string
+		data []byte
+		// maxPacked bounds the stream size, so matches are really used.
+		maxPacked int
+	}{
+		{"empty", nil, 8},
+		{"short", []byte("abc"), 0},
+		{"incompressible", random, 0},
+		{"several blocks", text, len(text) / 10},
+		{"exact block", text[:lzBlockSize], 0},
+		{"overlapping run", run, 64},
+		{"overlapping pattern", pattern, 64},
+		{"long literals and matches", append(append(random[:1000:1000], run...), random[:1000]...), 2200},
+	}
+	for _, tt := range tests {
+		packed := lzRoundTrip(t, tt.name, tt.data)
+		if tt.maxPacked > 0 && len(packed) > tt.maxPacked {
+			t.Errorf("%s: %d bytes packed into %d, want at most %d", tt.name, len(tt.data), len(packed), tt.maxPacked)
+		}
+	}
+
+	// Incompressible blocks are stored as they are, behind their header.
+	packed := lzRoundTrip(t, "incompressible", random)
+	if want := len(random) + 8*4 + 8; len(packed) != want {
+		t.Errorf("incompressible: %d bytes packed into %d, want %d", len(random), len(packed), want)
+	}
+}
+
+func TestLZCorruptBlock(t *testing.T) {
+	src := bytes.Repeat([]byte("0123456789abcdef, again and again: "), 100)
+	src = append(src, bytes.Repeat([]byte{0}, 300)...)
+	var table [1 << lzHashLog]int32
+	block := lzCompressBlock(nil, src, &table)
+	if got, err := lzDecompressBlock(nil, block, len(src)); err != nil || !bytes.Equal(got, src) {
+		t.Fatalf("intact block: %v", err)
+	}
+
+	for n := range len(block) {
+		if _, err := lzDecompressBlock(nil, block[:n], len(src)); err != errLZCorrupt {
+			t.Fatalf("block truncated to %d bytes: got %v, want errLZCorrupt", n, err)
+		}
+	}
+	for _, rawLen := range []int{0, len(src) - 1, len(src) + 1} {
+		if _, err := lzDecompressBlock(nil, block, rawLen); err != errLZCorrupt {
+			t.Errorf("block with length %d instead of %d: got %v, want errLZCorrupt", rawLen, len(src), err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		block []byte
+	}{
+		{"offset before the block", []byte{0x10, 'a', 5, 0}},
+		{"zero offset", []byte{0x10, 'a', 0, 0}},
+		{"missing offset", []byte{0x10, 'a', 1}},
+		{"literals past the end", []byte{0x50, 'a', 'b'}},
+		{"unterminated literal length", []byte{0xf0, 255, 255}},
+		{"unterminated match length", []byte{0x1f, 'a', 1, 0, 255}},
+		{"match past the length", []byte{0x1f, 'a', 1, 0, 200}},
+	}
+	for _, tt := range tests {
+		if _, err := lzDecompressBlock(nil, tt.block, 30); err != errLZCorrupt {
+			t.Errorf("%s: got %v, want errLZCorrupt", tt.name, err)
+		}
+	}
+
+	// Damage anywhere must be caught or decode to a block of the right
+	// length, never panic.
+	rng := rand.New(rand.NewSource(1))
+	for range 10000 {
+		damaged := bytes.Clone(block)
+		for range 1 + rng.Intn(4) {
+			damaged[rng.Intn(len(damaged))] = byte(rng.Intn(256))
+		}
+		got, err := lzDecompressBlock(nil, damaged, len(src))
+		if err != nil && err != errLZCorrupt {
+			t.Fatalf("damaged block: got %v, want errLZCorrupt", err)
+		}
+		if err == nil && len(got) != len(src) {
+			t.Fatalf("damaged block decoded to %d bytes, want %d", len(got), len(src))
+		}
+	}
+}
+
+func TestLZCorruptStream(t *testing.T) {
+	header := func(rawLen, packedLen uint32) []byte {
+		var hdr [8]byte
+		binary.LittleEndian.PutUint32(hdr[:4], rawLen)
+		binary.LittleEndian.PutUint32(hdr[4:], packedLen)
+		return hdr[:]
+	}
+	tests := []struct {
+		name   string
+		stream []byte
+		want   error
+	}{
+		{"block too large", header(lzBlockSize+1, 0), errLZCorrupt},
+		{"packed too large", header(16, lzBlockSize+1), errLZCorrupt},
+		{"bad block", append(header(16, 4), 0x10, 'a', 9, 0), errLZCorrupt},
+		{"truncated header", header(16, 4)[:5], io.ErrUnexpectedEOF},
+		{"truncated stored block", append(header(16, 0), "short"...), io.ErrUnexpectedEOF},
+		{"truncated block", append(header(16, 4), 0x10), io.ErrUnexpectedEOF},
+		{"missing end", append(header(1, 0), 'a'), io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		_, err := io.ReadAll(newLZReader(bytes.NewReader(tt.stream)))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	z := newLZWriter(&buf)
+	z.Write(bytes.Repeat([]byte("stream "), 20000))
+	z.Close()
+	stream := buf.Bytes()
+	for _, n := range []int{0, 3, 8, 100, len(stream) / 2, len(stream) - 8, len(stream) - 1} {
+		if _, err := io.ReadAll(newLZReader(bytes.NewReader(stream[:n]))); err == nil {
+			t.Errorf("stream truncated to %d of %d bytes: no error", n, len(stream))
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package huh
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata holds the metadata of a HUH file. Values are typed: a value is
+// a string, int64, float64, bool, time.Time, a []any list of values or a
+// nested Metadata object. The encoder also accepts the other Go integer
+// and float types, []string and map[string]any, and the decoder always
+// returns the types above.
+//
+// Metadata is stored as a JSON object. Integers are written without a
+// fraction and floats always with one, so the two survive a round trip,
+// and times are written as {"$time": "<RFC 3339>"}. Object keys starting
+// with "$" are reserved for such tags. Files written before typed
+// metadata hold only strings, which read back as strings.
+type Metadata map[string]any
+
+// Well-known metadata keys. The encoder checks their values against the
+// types listed here; other keys are free-form.
+const (
+	KeyAuthor       = "author"        // string
+	KeyTitle        = "title"         // string
+	KeyDescription  = "description"   // string
+	KeyCreationDate = "creation_date" // time
+	KeySource       = "source"        // string: the application that made the file
+	KeySourceFile   = "source_file"   // string: the file the image was converted from
+	KeySoftware     = "software"      // string
+	KeyKeywords     = "keywords"      // list of strings
+	KeyGPS          = "gps"           // object: latitude, longitude and altitude (floats)
+	KeyComment      = "comment"       // string
+	KeyCopyright    = "copyright"     // string
+	KeyCameraMake   = "camera_make"   // string
+	KeyCameraModel  = "camera_model"  // string
+	KeyLensModel    = "lens_model"    // string
+	KeyOrientation  = "orientation"   // int: EXIF orientation, 1 to 8
+	KeyExposureTime = "exposure_time" // float: seconds
+	KeyFNumber      = "f_number"      // float
+	KeyFocalLength  = "focal_length"  // float: millimeters
+	KeyISO          = "iso"           // int
+	KeyXMP          = "xmp"           // string: the XMP packet the image was imported with
+
+	// KeyOriginalOrientation is the EXIF orientation of an image whose
+	// pixels were turned upright on import, which leaves orientation at 1.
+	KeyOriginalOrientation = "original_orientation" // int
+)
+
+type valueKind int
+
+const (
+	kindAny valueKind = iota
+	kindString
+	kindInt
+	kindFloat
+	kindBool
+	kindTime
+	kindList
+	kindObject
+)
+
+var kindNames = [...]string{"any", "string", "int", "float", "bool", "time", "list", "object"}
+
+func (k valueKind) String() string { return kindNames[k] }
+
+// field describes the type of a well-known key.
+type field struct {
+	kind valueKind
+	// elem is the kind of the elements of a list.
+	elem valueKind
+	// fields are the known keys of an object.
+	fields map[string]field
+}
+
+var schema = map[string]field{
+	KeyAuthor:       {kind: kindString},
+	KeyTitle:        {kind: kindString},
+	KeyDescription:  {kind: kindString},
+	KeyCreationDate: {kind: kindTime},
+	KeySource:       {kind: kindString},
+	KeySourceFile:   {kind: kindString},
+	KeySoftware:     {kind: kindString},
+	KeyKeywords:     {kind: kindList, elem: kindString},
+	KeyGPS: {kind: kindObject, fields: map[string]field{
+		"latitude":  {kind: kindFloat},
+		"longitude": {kind: kindFloat},
+		"altitude":  {kind: kindFloat},
+	}},
+	KeyComment:      {kind: kindString},
+	KeyCopyright:    {kind: kindString},
+	KeyCameraMake:   {kind: kindString},
+	KeyCameraModel:  {kind: kindString},
+	KeyLensModel:    {kind: kindString},
+	KeyOrientation:  {kind: kindInt},
+	KeyExposureTime: {kind: kindFloat},
+	KeyFNumber:      {kind: kindFloat},
+	KeyFocalLength:  {kind: kindFloat},
+	KeyISO:          {kind: kindInt},
+	KeyXMP:          {kind: kindString},
+
+	KeyOriginalOrientation: {kind: kindInt},
+}
+
+// kindOf returns the kind of a normalized value.
+func kindOf(v any) valueKind {
+	switch v.(type) {
+	case string:
+		return kindString
+	case int64:
+		return kindInt
+	case float64:
+		return kindFloat
+	case bool:
+		return kindBool
+	case time.Time:
+		return kindTime
+	case []any:
+		return kindList
+	case Metadata:
+		return kindObject
+	}
+	return kindAny
+}
+
+// normalize converts v to one of the types Metadata values are decoded as.
+func normalize(v any) (any, error) {
+	switch v := v.(type) {
+	case string, int64, bool, time.Time:
+		return v, nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("metadata value %v is not a finite number", v)
+		}
+		return v, nil
+	case float32:
+		return normalize(float64(v))
+	case int, int8, int16, int32, uint8, uint16, uint32:
+		return reflect.ValueOf(v).Convert(reflect.TypeOf(int64(0))).Int(), nil
+	case uint, uint64:
+		n := reflect.ValueOf(v).Uint()
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("metadata value %d overflows int64", n)
+		}
+		return int64(n), nil
+	case []string:
+		list := make([]any, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list, nil
+	case []any:
+		list := make([]any, len(v))
+		for i, e := range v {
+			var err error
+			if list[i], err = normalize(e); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]any:
+		return normalize(Metadata(v))
+	case Metadata:
+		m := make(Metadata, len(v))
+		for k, e := range v {
+			if strings.HasPrefix(k, "$") {
+				return nil, fmt.Errorf("metadata key %q is reserved", k)
+			}
+			n, err := normalize(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			m[k] = n
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported metadata value type %T", v)
+}
+
+// conform checks a normalized value against f, converting integers where
+// a float is expected.
+func (f field) conform(v any) (any, error) {
+	kind := kindOf(v)
+	switch {
+	case f.kind == kindAny || f.kind == kind:
+	case f.kind == kindFloat && kind == kindInt:
+		return float64(v.(int64)), nil
+	default:
+		return nil, fmt.Errorf("must be %v, not %v", f.kind, kind)
+	}
+	switch v := v.(type) {
+	case []any:
+		elem := field{kind: f.elem}
+		for i, e := range v {
+			var err error
+			if v[i], err = elem.conform(e); err != nil {
+				return nil, fmt.Errorf("element %d %w", i, err)
+			}
+		}
+	case Metadata:
+		for k, e := range v {
+			if sub, ok := f.fields[k]; ok {
+				var err error
+				if v[k], err = sub.conform(e); err != nil {
+					return nil, fmt.Errorf("%s %w", k, err)
+				}
+			}
+		}
+	}
+	return v, nil
+}
+
+// Validate checks that every value has a supported type and that the
+// well-known keys have the types of their schema.
+func (m Metadata) Validate() error {
+	_, err := m.normalized()
+	return err
+}
+
+// normalized returns a copy of m with every value converted to its
+// decoded type and checked against the schema.
+func (m Metadata) normalized() (Metadata, error) {
+	v, err := normalize(m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HUH metadata: %w", err)
+	}
+	n := v.(Metadata)
+	for k, e := range n {
+		f, ok := schema[k]
+		if !ok {
+			continue
+		}
+		if n[k], err = f.conform(e); err != nil {
+			return nil, fmt.Errorf("invalid HUH metadata: %s %w", k, err)
+		}
+	}
+	return n, nil
+}
+
+// MarshalJSON encodes m in the typed JSON form stored in HUH files.
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	n, err := m.normalized()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeValue(&buf, n)
+	return buf.Bytes(), nil
+}
+
+// writeValue appends the JSON form of a normalized value to buf.
+func writeValue(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		s, _ := json.Marshal(v)
+		buf.Write(s)
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		buf.WriteString(s)
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case time.Time:
+		buf.WriteString(`{"$time":"`)
+		buf.WriteString(v.Format(time.RFC3339Nano))
+		buf.WriteString(`"}`)
+	case []any:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeValue(buf, e)
+		}
+		buf.WriteByte(']')
+	case Metadata:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeValue(buf, k)
+			buf.WriteByte(':')
+			writeValue(buf, v[k])
+		}
+		buf.WriteByte('}')
+	}
+}
+
+// UnmarshalJSON decodes the typed JSON form of metadata, including the
+// string-only metadata of older files.
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		// Some early encoders wrote null for missing metadata.
+		*m = nil
+		return nil
+	}
+	v, err := readValue(raw)
+	if err != nil {
+		return err
+	}
+	*m = v.(Metadata)
+	for k, e := range *m {
+		if f, ok := schema[k]; ok {
+			(*m)[k] = f.upgrade(e)
+		}
+	}
+	return nil
+}
+
+// readValue converts a value decoded by encoding/json with UseNumber into
+// its Metadata type.
+func readValue(v any) (any, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, errors.New("null metadata value")
+	case json.Number:
+		if !strings.ContainsAny(string(v), ".eE") {
+			return strconv.ParseInt(string(v), 10, 64)
+		}
+		return strconv.ParseFloat(string(v), 64)
+	case []any:
+		for i, e := range v {
+			var err error
+			if v[i], err = readValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case map[string]any:
+		if s, ok := v["$time"].(string); ok && len(v) == 1 {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+		m := make(Metadata, len(v))
+		for k, e := range v {
+			if strings.HasPrefix(k, "$") {
+				return nil, fmt.Errorf("unknown metadata tag %q", k)
+			}
+			var err error
+			if m[k], err = readValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return v, nil
+}
+
+// upgrade converts the string values older files stored for well-known
+// keys into their schema type, when they parse. Values that do not are
+// kept as they are rather than failing the decode.
+func (f field) upgrade(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	switch f.kind {
+	case kindTime:
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t
+		}
+	case kindInt:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case kindFloat:
+		if n, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+			return n
+		}
+	case kindBool:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+// ParseValue parses the text form of a metadata value for key, as given on
+// a command line. typ is "string", "int", "float", "bool", "time" (RFC
+// 3339) or "json", the typed JSON form, which also covers lists and
+// objects. An empty typ means the schema type of a well-known key, or
+// string for any other key.
+func ParseValue(key, typ, s string) (any, error) {
+	kind := kindString
+	if f, ok := schema[key]; ok {
+		kind = f.kind
+	}
+	if typ != "" {
+		kind = kindAny
+		for k, name := range kindNames {
+			if name == typ && valueKind(k) <= kindTime {
+				kind = valueKind(k)
+			}
+		}
+		if kind == kindAny && typ != "json" {
+			return nil, fmt.Errorf("unknown metadata type %q", typ)
+		}
+	}
+
+	var v any
+	switch kind {
+	case kindString:
+		v = s
+	case kindInt, kindFloat, kindBool, kindTime:
+		v = field{kind: kind}.upgrade(s)
+		if _, ok := v.(string); ok {
+			return nil, fmt.Errorf("%q is not a valid %v", s, kind)
+		}
+	default:
+		if !json.Valid([]byte(s)) {
+			return nil, fmt.Errorf("invalid JSON metadata value: %s", s)
+		}
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		var raw any
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		var err error
+		if v, err = readValue(raw); err != nil {
+			return nil, err
+		}
+	}
+	n, err := Metadata{key: v}.normalized()
+	if err != nil {
+		return nil, err
+	}
+	return n[key], nil
+}
+
+// FormatValue returns the text form of a metadata value, which ParseValue
+// reads back: strings as they are, times in RFC 3339, numbers and booleans
+// as in Go, and lists and objects in the typed JSON form.
+func FormatValue(v any) (string, error) {
+	n, err := normalize(v)
+	if err != nil {
+		return "", err
+	}
+	switch n := n.(type) {
+	case string:
+		return n, nil
+	case time.Time:
+		return n.Format(time.RFC3339Nano), nil
+	}
+	var buf bytes.Buffer
+	writeValue(&buf, n)
+	return buf.String(), nil
+}
@@ -0,0 +1,106 @@
+package huh
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	when := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	meta := Metadata{
+		KeyAuthor:       "Jane Doe",
+		KeyCreationDate: when,
+		KeyKeywords:     []string{"cat", "sofa"},
+		KeyGPS:          map[string]any{"latitude": 48, "longitude": 2.35, "altitude": float32(35)},
+		"iso":           uint16(400),
+		"exposure":      2.0,
+		"flash":         false,
+		"lens":          Metadata{"focal_length": 50, "tags": []any{"prime", int8(1)}},
+	}
+	want := Metadata{
+		KeyAuthor:       "Jane Doe",
+		KeyCreationDate: when,
+		KeyKeywords:     []any{"cat", "sofa"},
+		KeyGPS:          Metadata{"latitude": 48.0, "longitude": 2.35, "altitude": 35.0},
+		"iso":           int64(400),
+		"exposure":      2.0,
+		"flash":         false,
+		"lens":          Metadata{"focal_length": int64(50), "tags": []any{"prime", int64(1)}},
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, testImage(image.Rect(0, 0, 4, 4)), &Options{Metadata: meta}); err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := new(Decoder).Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+
+	for name, bad := range map[string]Metadata{
+		"author not a string":  {KeyAuthor: 42},
+		"keyword not a string": {KeyKeywords: []any{"cat", 1}},
+		"gps not an object":    {KeyGPS: "48.85,2.35"},
+		"reserved key":         {"lens": Metadata{"$time": "now"}},
+		"NaN":                  {"exposure": math.NaN()},
+		"overflow":             {"iso": uint64(math.MaxUint64)},
+		"unsupported type":     {"size": image.Pt(1, 2)},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+		if err := Encode(&buf, testImage(image.Rect(0, 0, 1, 1)), &Options{Metadata: bad}); err == nil {
+			t.Errorf("%s: encoded", name)
+		}
+	}
+}
+
+func TestMetadataUpgrade(t *testing.T) {
+	// Files written before typed metadata hold only strings.
+	legacy := `{"author":"Jane","creation_date":"2024-05-01T10:30:00Z","title":"12","note":"2024-05-01T10:30:00Z","bad_date":"yesterday"}`
+	file := append([]byte(Magic), 2)
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(legacy)))
+	file = append(file, legacy...)
+	file = binary.LittleEndian.AppendUint32(file, 1)
+	file = binary.LittleEndian.AppendUint32(file, 1)
+	buf := bytes.NewBuffer(file)
+	fw, _ := flate.NewWriter(buf, flate.BestCompression)
+	fw.Write([]byte{1, 2, 3})
+	fw.Close()
+
+	_, meta, err := new(Decoder).DecodeConfig(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{
+		"author":        "Jane",
+		"creation_date": time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC),
+		"title":         "12",
+		"note":          "2024-05-01T10:30:00Z",
+		"bad_date":      "yesterday",
+	}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("got %#v\nwant %#v", meta, want)
+	}
+
+	// A well-known key whose old value does not parse stays a string.
+	var m Metadata
+	if err := json.Unmarshal([]byte(`{"creation_date":"last week"}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m[KeyCreationDate] != "last week" {
+		t.Errorf("creation_date is %#v", m[KeyCreationDate])
+	}
+	if err := json.Unmarshal([]byte(`null`), &m); err != nil || m != nil {
+		t.Errorf("null metadata gives %v, %v", m, err)
+	}
+}
@@ -0,0 +1,333 @@
+package huh
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+
+	"huh/src/internal/imageutil"
+)
+
+// pixelFormat is the layout of a pixel in the uncompressed rows: the color
+// type and the number of bits per sample, 8 or 16. 16-bit samples are
+// stored little-endian, like every other integer in the format. Palette
+// images store one 8-bit index per pixel into palette.
+type pixelFormat struct {
+	colorType ColorType
+	depth     int
+	palette   color.Palette
+	// index maps the colors of palette back to their index while encoding
+	// images that are not *image.Paletted.
+	index map[color.NRGBA]uint8
+}
+
+func (f pixelFormat) bytesPerPixel() int {
+	return f.colorType.channels() * f.depth / 8
+}
+
+// decodedBytesPerPixel is the size of a pixel in the image built by the
+// decoder.
+func (f pixelFormat) decodedBytesPerPixel() int {
+	switch f.colorType {
+	case ColorGray, ColorPalette:
+		return f.depth / 8
+	}
+	return 4 * f.depth / 8
+}
+
+func (f pixelFormat) colorModel() color.Model {
+	switch {
+	case f.colorType == ColorPalette:
+		return f.palette
+	case f.colorType == ColorGray && f.depth == 16:
+		return color.Gray16Model
+	case f.colorType == ColorGray:
+		return color.GrayModel
+	case f.colorType == ColorRGBA && f.depth == 16:
+		return color.NRGBA64Model
+	case f.colorType == ColorRGBA:
+		return color.NRGBAModel
+	case f.depth == 16:
+		return color.RGBA64Model
+	}
+	return color.RGBAModel
+}
+
+// newImage allocates the image type the decoder returns for f: RGB
+// decodes to *image.RGBA or *image.RGBA64, RGBA, which is stored with
+// straight alpha, to *image.NRGBA or *image.NRGBA64, gray to *image.Gray or
+// *image.Gray16 and palette to *image.Paletted.
+func (f pixelFormat) newImage(rect image.Rectangle) (img image.Image, pix []byte, stride int) {
+	switch {
+	case f.colorType == ColorPalette:
+		m := image.NewPaletted(rect, f.palette)
+		return m, m.Pix, m.Stride
+	case f.colorType == ColorGray && f.depth == 16:
+		m := image.NewGray16(rect)
+		return m, m.Pix, m.Stride
+	case f.colorType == ColorGray:
+		m := image.NewGray(rect)
+		return m, m.Pix, m.Stride
+	case f.colorType == ColorRGBA && f.depth == 16:
+		m := image.NewNRGBA64(rect)
+		return m, m.Pix, m.Stride
+	case f.colorType == ColorRGBA:
+		m := image.NewNRGBA(rect)
+		return m, m.Pix, m.Stride
+	case f.depth == 16:
+		m := image.NewRGBA64(rect)
+		return m, m.Pix, m.Stride
+	}
+	m := image.NewRGBA(rect)
+	return m, m.Pix, m.Stride
+}
+
+// depthOf returns the bit depth that keeps every sample of img intact.
+func depthOf(img image.Image) int {
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+		return 16
+	}
+	return 8
+}
+
+// colorTypeOf picks the color type that stores img without loss.
+func colorTypeOf(img image.Image) ColorType {
+	switch m := img.ColorModel().(type) {
+	case color.Palette:
+		if len(m) > 0 && len(m) <= 256 {
+			return ColorPalette
+		}
+	default:
+		if m == color.GrayModel || m == color.Gray16Model {
+			return ColorGray
+		}
+	}
+	if !imageutil.Opaque(img) {
+		return ColorRGBA
+	}
+	return ColorRGB
+}
+
+// paletteOf returns the palette to store img with, and the index to look
+// up its colors in when img is not an *image.Paletted with that palette.
+func paletteOf(img image.Image) (color.Palette, map[color.NRGBA]uint8, error) {
+	if p, ok := img.ColorModel().(color.Palette); ok && len(p) > 0 && len(p) <= 256 {
+		if _, ok := img.(*image.Paletted); ok {
+			return p, nil, nil
+		}
+	}
+
+	var palette color.Palette
+	index := make(map[color.NRGBA]uint8)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if _, ok := index[c]; ok {
+				continue
+			}
+			if len(palette) == 256 {
+				return nil, nil, errors.New("image has more than 256 colors for a HUH palette")
+			}
+			index[c] = uint8(len(palette))
+			palette = append(palette, c)
+		}
+	}
+	if len(palette) == 0 {
+		palette = color.Palette{color.NRGBA{}}
+	}
+	return palette, index, nil
+}
+
+// encodePalette returns the PLTE chunk of p: four bytes of straight RGBA
+// per entry.
+func encodePalette(p color.Palette) []byte {
+	data := make([]byte, 0, 4*len(p))
+	for _, c := range p {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		data = append(data, n.R, n.G, n.B, n.A)
+	}
+	return data
+}
+
+// decodePalette parses a PLTE chunk.
+func decodePalette(data []byte) (color.Palette, error) {
+	if len(data) == 0 || len(data) > 4*256 || len(data)%4 != 0 {
+		return nil, errors.New("invalid palette length")
+	}
+	p := make(color.Palette, len(data)/4)
+	for i := range p {
+		p[i] = color.NRGBA{data[4*i], data[4*i+1], data[4*i+2], data[4*i+3]}
+	}
+	return p, nil
+}
+
+// readRow copies the pixels of img from (x0, y) to (x0+len(dst)/bpp, y)
+// into dst, with fast paths for the common in-memory image types.
+func readRow(img image.Image, f pixelFormat, x0, y int, dst []byte) {
+	switch {
+	case f.colorType == ColorPalette:
+		readRowPalette(img, f, x0, y, dst)
+		return
+	case f.colorType == ColorGray:
+		readRowGray(img, f, x0, y, dst)
+		return
+	case f.depth == 16:
+		readRow16(img, f, x0, y, dst)
+		return
+	}
+	switch m := img.(type) {
+	case *image.NRGBA:
+		src := m.Pix[m.PixOffset(x0, y):]
+		if f.colorType == ColorRGBA {
+			copy(dst, src)
+			return
+		}
+		for i, j := 0, 0; i < len(dst); i, j = i+3, j+4 {
+			dst[i], dst[i+1], dst[i+2] = src[j], src[j+1], src[j+2]
+		}
+		return
+	case *image.RGBA:
+		// Premultiplied and straight alpha agree on opaque pixels.
+		if f.colorType == ColorRGB {
+			src := m.Pix[m.PixOffset(x0, y):]
+			for i, j := 0, 0; i < len(dst); i, j = i+3, j+4 {
+				dst[i], dst[i+1], dst[i+2] = src[j], src[j+1], src[j+2]
+			}
+			return
+		}
+	}
+
+	bpp := f.bytesPerPixel()
+	for i, x := 0, x0; i < len(dst); i, x = i+bpp, x+1 {
+		if f.colorType == ColorRGBA {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = c.R, c.G, c.B, c.A
+		} else {
+			r, g, b, _ := img.At(x, y).RGBA()
+			dst[i], dst[i+1], dst[i+2] = byte(r>>8), byte(g>>8), byte(b>>8)
+		}
+	}
+}
+
+// readRow16 is readRow for 16-bit RGB and RGBA. Go keeps 16-bit pixels
+// big-endian, so even the fast paths swap every sample.
+func readRow16(img image.Image, f pixelFormat, x0, y int, dst []byte) {
+	var src []byte
+	switch m := img.(type) {
+	case *image.NRGBA64:
+		src = m.Pix[m.PixOffset(x0, y):]
+	case *image.RGBA64:
+		if f.colorType == ColorRGB {
+			src = m.Pix[m.PixOffset(x0, y):]
+		}
+	}
+	channels := f.colorType.channels()
+	if src != nil {
+		for i, j := 0, 0; i < len(dst); i, j = i+2*channels, j+8 {
+			for c := 0; c < 2*channels; c += 2 {
+				dst[i+c], dst[i+c+1] = src[j+c+1], src[j+c]
+			}
+		}
+		return
+	}
+
+	var samples [4]uint16
+	for i, x := 0, x0; i < len(dst); i, x = i+2*channels, x+1 {
+		if f.colorType == ColorRGBA {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			samples = [4]uint16{c.R, c.G, c.B, c.A}
+		} else {
+			r, g, b, _ := img.At(x, y).RGBA()
+			samples = [4]uint16{uint16(r), uint16(g), uint16(b)}
+		}
+		for c := 0; c < channels; c++ {
+			dst[i+2*c], dst[i+2*c+1] = byte(samples[c]), byte(samples[c]>>8)
+		}
+	}
+}
+
+func readRowGray(img image.Image, f pixelFormat, x0, y int, dst []byte) {
+	switch m := img.(type) {
+	case *image.Gray:
+		if f.depth == 8 {
+			copy(dst, m.Pix[m.PixOffset(x0, y):])
+			return
+		}
+	case *image.Gray16:
+		if f.depth == 16 {
+			src := m.Pix[m.PixOffset(x0, y):]
+			for i := 0; i < len(dst); i += 2 {
+				dst[i], dst[i+1] = src[i+1], src[i]
+			}
+			return
+		}
+	}
+
+	for i, x := 0, x0; i < len(dst); x++ {
+		if f.depth == 16 {
+			v := color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y
+			dst[i], dst[i+1] = byte(v), byte(v>>8)
+			i += 2
+		} else {
+			dst[i] = color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+			i++
+		}
+	}
+}
+
+func readRowPalette(img image.Image, f pixelFormat, x0, y int, dst []byte) {
+	if f.index == nil {
+		m := img.(*image.Paletted)
+		copy(dst, m.Pix[m.PixOffset(x0, y):])
+		return
+	}
+	for i := range dst {
+		dst[i] = f.index[color.NRGBAModel.Convert(img.At(x0+i, y)).(color.NRGBA)]
+	}
+}
+
+// checkRow rejects palette indices that have no palette entry, which
+// would make the decoded *image.Paletted panic.
+func (f pixelFormat) checkRow(row []byte) error {
+	if f.colorType != ColorPalette {
+		return nil
+	}
+	for _, i := range row {
+		if int(i) >= len(f.palette) {
+			return fmt.Errorf("palette index %d out of range", i)
+		}
+	}
+	return nil
+}
+
+// writeRow converts a row of f into the Pix layout of the image from
+// newImage.
+func writeRow(f pixelFormat, dst, row []byte) {
+	switch {
+	case f.colorType == ColorGray && f.depth == 16:
+		for i := 0; i < len(row); i += 2 {
+			dst[i], dst[i+1] = row[i+1], row[i]
+		}
+	case f.colorType == ColorGray, f.colorType == ColorPalette:
+		copy(dst, row)
+	case f.depth == 16:
+		channels := f.colorType.channels()
+		for i, j := 0, 0; i < len(row); i, j = i+2*channels, j+8 {
+			for c := 0; c < 2*channels; c += 2 {
+				dst[j+c], dst[j+c+1] = row[i+c+1], row[i+c]
+			}
+			if f.colorType == ColorRGB {
+				dst[j+6], dst[j+7] = 0xff, 0xff
+			}
+		}
+	case f.colorType == ColorRGBA:
+		copy(dst, row)
+	default:
+		for i, j := 0, 0; i < len(row); i, j = i+3, j+4 {
+			dst[j], dst[j+1], dst[j+2], dst[j+3] = row[i], row[i+1], row[i+2], 255
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package huh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+)
+
+// ErrOutOfBounds is wrapped by the error DecodeRegion returns when the
+// requested region does not overlap the image.
+var ErrOutOfBounds = errors.New("region is outside the HUH image")
+
+// DecodeRegion reads the part of a HUH image inside rect, given in the
+// coordinates of the image returned by Decode, together with the metadata.
+// The result covers the intersection of rect and the image bounds, which
+// must not be empty, or the error wraps ErrOutOfBounds. For tiled files
+// only the tiles that overlap rect are read and checked, seeking past the
+// others. Files without tiles hold a single pixel stream, which is
+// decompressed in full but only copied where it falls inside rect.
+func (d *Decoder) DecodeRegion(r io.ReadSeeker, rect image.Rectangle) (image.Image, Metadata, error) {
+	br := bufio.NewReader(r)
+	h, src, err := d.openBuffered(br, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	bounds := h.bounds()
+	region := rect.Intersect(bounds)
+	if region.Empty() {
+		return nil, nil, fmt.Errorf("%w: %v does not overlap %v", ErrOutOfBounds, rect, bounds)
+	}
+
+	img, fn := h.newImage(region)
+	if !h.tiled() {
+		if err := src(d, fn); err != nil {
+			return nil, nil, err
+		}
+		return img, h.metadata, nil
+	}
+
+	// openBuffered stops right after the header of the first DATA chunk,
+	// and the chunks of the other tiles follow it back to back.
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, err
+	}
+	offset := pos - int64(br.Buffered()) - 8
+
+	g := h.grid()
+	area := region.Sub(h.origin)
+	var tiles []int
+	for i := 0; i < g.count(); i++ {
+		if g.rect(i).Overlaps(area) {
+			tiles = append(tiles, i)
+		}
+	}
+	w := newTileWorkers(d.Concurrency, len(tiles), d.Progress)
+	next := 0
+	for _, i := range tiles {
+		for ; next < i; next++ {
+			offset += 12 + int64(h.tileSizes[next])
+		}
+		if h.tileSizes[i] > h.maxTileSize(i) {
+			w.fail(damaged(SectionPixels, fmt.Errorf("tile %d does not match its index entry", i)))
+			break
+		}
+		data, err := readTile(r, offset, h.tileSizes[i])
+		if err != nil {
+			w.fail(err)
+			break
+		}
+		w.decode(h, i, data, fn)
+		if w.failed() {
+			break
+		}
+	}
+	if err := w.wait(); err != nil {
+		return nil, nil, err
+	}
+	return img, h.metadata, nil
+}
+
+// readTile reads the DATA chunk at offset and checks it against its index
+// entry and CRC.
+func readTile(r io.ReadSeeker, offset int64, size uint32) ([]byte, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 12+int(size))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, damaged(SectionPixels, err)
+	}
+	if string(buf[4:8]) != chunkData || binary.LittleEndian.Uint32(buf) != size {
+		return nil, damaged(SectionPixels, errors.New("tile does not match its index entry"))
+	}
+	body := buf[8 : 8+size]
+	if binary.LittleEndian.Uint32(buf[8+size:]) != crc32.ChecksumIEEE(buf[4:8+size]) {
+		return nil, damaged(SectionPixels, ErrChecksum)
+	}
+	return body, nil
+}
+
+// DecodeRegion reads the part of a HUH image inside rect from r. Use a
+// Decoder to also get the metadata.
+func DecodeRegion(r io.ReadSeeker, rect image.Rectangle) (image.Image, error) {
+	img, _, err := new(Decoder).DecodeRegion(r, rect)
+	return img, err
+}
@@ -0,0 +1,61 @@
+package huh
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestDecodeRegion(t *testing.T) {
+	src := testImage(image.Rect(-10, 5, 40, 42))
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, &Options{KeepOrigin: true, TileSize: 8}); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	full, err := Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := full.Bounds()
+	tests := []struct {
+		name string
+		rect image.Rectangle
+	}{
+		{"one tile", image.Rect(-10, 5, -2, 13)},
+		{"across tile boundaries", image.Rect(-5, 10, 12, 30)},
+		{"pixels around a tile corner", image.Rect(-3, 12, -1, 14)},
+		{"last partial tile", image.Rect(38, 40, 40, 42)},
+		{"whole image", b},
+		{"clipped", image.Rect(-100, 20, 100, 21)},
+	}
+	for _, tt := range tests {
+		got, err := DecodeRegion(bytes.NewReader(file), tt.rect)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := full.(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(tt.rect)
+		sameImage(t, tt.name, got, want)
+	}
+
+	for _, rect := range []image.Rectangle{image.Rect(0, 0, 40, 5), image.Rect(40, 5, 50, 42), {}} {
+		if _, err := DecodeRegion(bytes.NewReader(file), rect); !errors.Is(err, ErrOutOfBounds) {
+			t.Errorf("%v: got %v, want ErrOutOfBounds", rect, err)
+		}
+	}
+
+	// Only the tiles that overlap the region are read: damage to the
+	// first tile goes unnoticed unless the region covers it.
+	data := locateChunk(t, file, chunkData)
+	damagedFile := bytes.Clone(file)
+	damagedFile[data.at+8] ^= 0xff
+	if _, err := DecodeRegion(bytes.NewReader(damagedFile), image.Rect(20, 20, 30, 30)); err != nil {
+		t.Errorf("region away from the damage: %v", err)
+	}
+	if _, err := DecodeRegion(bytes.NewReader(damagedFile), image.Rect(-10, 5, -9, 6)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("region over the damage: got %v, want ErrCorrupt", err)
+	}
+}
@@ -0,0 +1,116 @@
+package huh
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// The thmb chunk holds a small preview of the image, itself encoded as a
+// complete HUH file. It comes before INDX and the pixel data, so a reader
+// that only wants the preview stops long before the bulk of the file.
+const chunkThumbnail = "thmb"
+
+// DefaultThumbnailSize is a good Options.Thumbnail for gallery grids.
+const DefaultThumbnailSize = 256
+
+// ErrNoThumbnail is returned by DecodeThumbnail for files that were
+// written without a thumbnail.
+var ErrNoThumbnail = errors.New("HUH file has no thumbnail")
+
+// Thumbnail scales img down to fit in a size×size square, keeping its
+// aspect ratio, by averaging the pixels each thumbnail pixel covers.
+// Images that already fit are returned unchanged.
+func Thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	if size <= 0 || (sw <= size && sh <= size) {
+		return img
+	}
+	dw, dh := size, size
+	if sw > sh {
+		dh = max(1, sh*size/sw)
+	} else {
+		dw = max(1, sw*size/sh)
+	}
+
+	// spans[i] is the first source column (or row) of thumbnail pixel i.
+	spans := func(src, dst int) []int {
+		s := make([]int, dst+1)
+		for i := range s {
+			s[i] = i * src / dst
+		}
+		return s
+	}
+	xs, ys := spans(sw, dw), spans(sh, dh)
+
+	thumb := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	sums := make([][4]uint64, dw)
+	for dy := 0; dy < dh; dy++ {
+		clear(sums)
+		for y := ys[dy]; y < ys[dy+1]; y++ {
+			for dx := 0; dx < dw; dx++ {
+				for x := xs[dx]; x < xs[dx+1]; x++ {
+					r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					sums[dx][0] += uint64(r)
+					sums[dx][1] += uint64(g)
+					sums[dx][2] += uint64(b)
+					sums[dx][3] += uint64(a)
+				}
+			}
+		}
+		for dx, s := range sums {
+			n := uint64((xs[dx+1] - xs[dx]) * (ys[dy+1] - ys[dy]))
+			// The sums are premultiplied, so transparent pixels do not
+			// bleed their color into the average.
+			c := color.RGBA64{uint16(s[0] / n), uint16(s[1] / n), uint16(s[2] / n), uint16(s[3] / n)}
+			thumb.SetNRGBA(dx, dy, color.NRGBAModel.Convert(c).(color.NRGBA))
+		}
+	}
+	return thumb
+}
+
+// encodeThumbnail returns the thmb chunk for img, or nil if img already
+// fits in the thumbnail size.
+func encodeThumbnail(img image.Image, opts *Options) ([]byte, error) {
+	thumb := Thumbnail(img, opts.Thumbnail)
+	if thumb == img {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	err := Encode(&buf, thumb, &Options{
+		Compression: opts.Compression,
+		Level:       opts.Level,
+		Concurrency: opts.Concurrency,
+	})
+	return buf.Bytes(), err
+}
+
+// DecodeThumbnail reads only the embedded thumbnail of a HUH file,
+// without touching its pixel data. Files without one, including every v2
+// file, give ErrNoThumbnail; callers can fall back to Decode and Thumbnail.
+func (d *Decoder) DecodeThumbnail(r io.Reader) (image.Image, error) {
+	c, err := findChunk(r, chunkThumbnail)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, ErrNoThumbnail
+	}
+	inner := &Decoder{Limits: d.Limits, Concurrency: d.Concurrency}
+	img, _, err := inner.Decode(c)
+	if err != nil {
+		return nil, damaged(chunkSection(chunkThumbnail), err)
+	}
+	if err := c.end(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// DecodeThumbnail reads the embedded thumbnail of a HUH file from r.
+func DecodeThumbnail(r io.Reader) (image.Image, error) {
+	return new(Decoder).DecodeThumbnail(r)
+}
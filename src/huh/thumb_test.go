@@ -0,0 +1,76 @@
+package huh
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestThumbnail(t *testing.T) {
+	// Each 2×2 block averages an opaque red pixel with three transparent
+	// ones, whose color must not bleed into the thumbnail.
+	src := image.NewNRGBA(image.Rect(10, 10, 30, 20))
+	for y := 10; y < 20; y += 2 {
+		for x := 10; x < 30; x += 2 {
+			src.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+			src.SetNRGBA(x+1, y, color.NRGBA{0, 255, 0, 0})
+		}
+	}
+	thumb := Thumbnail(src, 10)
+	if thumb.Bounds() != image.Rect(0, 0, 10, 5) {
+		t.Fatalf("bounds %v, want 10x5", thumb.Bounds())
+	}
+	if c := thumb.At(3, 2); c != (color.NRGBA{255, 0, 0, 63}) {
+		t.Errorf("pixel is %v, want translucent red", c)
+	}
+	if Thumbnail(src, 20) != image.Image(src) {
+		t.Error("image that fits was scaled")
+	}
+}
+
+func TestDecodeThumbnail(t *testing.T) {
+	img := testImage(image.Rect(0, 0, 90, 60))
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Thumbnail: 30}); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	// Nothing after the thumbnail is read, so the pixel data may be gone.
+	thmb := locateChunk(t, file, chunkThumbnail)
+	got, err := DecodeThumbnail(bytes.NewReader(file[:thmb.at+thmb.size]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameImage(t, "thumbnail", got, Thumbnail(img, 30))
+	if full, err := Decode(bytes.NewReader(file)); err != nil {
+		t.Error(err)
+	} else {
+		sameImage(t, "full", full, img)
+	}
+
+	damagedFile := bytes.Clone(file)
+	damagedFile[thmb.at+thmb.size-1] ^= 0xff
+	var se *SectionError
+	if _, err := DecodeThumbnail(bytes.NewReader(damagedFile)); !errors.As(err, &se) || se.Section != chunkSection(chunkThumbnail) {
+		t.Errorf("damaged thumbnail: got %v", err)
+	}
+
+	for name, opts := range map[string]*Options{
+		"no thumbnail":    nil,
+		"image that fits": {Thumbnail: 100},
+	} {
+		buf.Reset()
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecodeThumbnail(&buf); !errors.Is(err, ErrNoThumbnail) {
+			t.Errorf("%s: got %v, want ErrNoThumbnail", name, err)
+		}
+	}
+	if _, err := DecodeThumbnail(bytes.NewReader(v2Header(2, 1, 1))); !errors.Is(err, ErrNoThumbnail) {
+		t.Errorf("v2: got %v, want ErrNoThumbnail", err)
+	}
+}
@@ -0,0 +1,298 @@
+package huh
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"runtime"
+	"sync"
+
+	"huh/src/internal/imageutil"
+)
+
+// DefaultTileSize is the tile width and height used when
+// Options.TileSize is zero.
+const DefaultTileSize = 256
+
+// maxTiles bounds the size of the INDX chunk a decoder will allocate.
+const maxTiles = 1 << 20
+
+// Tiled files split the image into a grid of tiles, stored in row-major
+// order. Every tile is filtered and compressed on its own, so tiles can be
+// encoded and decoded in parallel and located without inflating the ones
+// before them. The INDX chunk holds the compressed size of each tile as a
+// uint32, and each tile is then stored in exactly one DATA chunk.
+const chunkIndex = "INDX"
+
+// tileGrid describes how an image is cut into tiles.
+type tileGrid struct {
+	width, height int
+	tileW, tileH  int
+}
+
+func (g tileGrid) cols() int  { return (g.width + g.tileW - 1) / g.tileW }
+func (g tileGrid) rows() int  { return (g.height + g.tileH - 1) / g.tileH }
+func (g tileGrid) count() int { return g.cols() * g.rows() }
+
+// rect returns the pixels covered by tile i, relative to the image origin.
+func (g tileGrid) rect(i int) image.Rectangle {
+	x0, y0 := (i%g.cols())*g.tileW, (i/g.cols())*g.tileH
+	return image.Rect(x0, y0, min(x0+g.tileW, g.width), min(y0+g.tileH, g.height))
+}
+
+// maxPacked bounds the compressed size of raw bytes of pixel data under
+// any codec, so a corrupt size cannot make the decoder allocate much more
+// than the data could ever need.
+func maxPacked(raw int) uint32 {
+	return uint32(min(uint64(raw)+uint64(raw)/8+1024, 1<<32-1))
+}
+
+func concurrency(n int) int {
+	if n <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return n
+}
+
+// tileEncoder compresses tiles, reusing its DEFLATE state between them.
+type tileEncoder struct {
+	img    image.Image
+	format pixelFormat
+	codec  Compression
+	level  int
+	// quality, if positive, selects the lossy layout.
+	quality int
+	fw      *flate.Writer
+}
+
+func (e *tileEncoder) encode(r image.Rectangle) ([]byte, error) {
+	var buf bytes.Buffer
+	var compressor io.WriteCloser
+	if e.codec == CompressionDeflate && e.fw != nil {
+		e.fw.Reset(&buf)
+		compressor = e.fw
+	} else {
+		var err error
+		if compressor, err = newCompressor(&buf, e.codec, e.level); err != nil {
+			return nil, err
+		}
+		if fw, ok := compressor.(*flate.Writer); ok {
+			e.fw = fw
+		}
+	}
+
+	if e.quality > 0 {
+		if err := e.encodeLossy(compressor, r); err != nil {
+			return nil, err
+		}
+	} else {
+		bpp := e.format.bytesPerPixel()
+		row := make([]byte, r.Dx()*bpp)
+		filter := newRowFilter(bpp, len(row))
+		min := e.img.Bounds().Min
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			readRow(e.img, e.format, min.X+r.Min.X, min.Y+y, row)
+			if _, err := compressor.Write(filter.filter(row)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeTiles compresses every tile of g on n goroutines, lossily if
+// quality is positive.
+func encodeTiles(img image.Image, g tileGrid, format pixelFormat, codec Compression, level, quality, n int, progress func(float32)) ([][]byte, error) {
+	tiles := make([][]byte, g.count())
+	errs := make([]error, g.count())
+	next := make(chan int)
+	var mu sync.Mutex
+	done := 0
+
+	var wg sync.WaitGroup
+	for range min(n, len(tiles)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := &tileEncoder{img: img, format: format, codec: codec, level: level, quality: quality}
+			for i := range next {
+				tiles[i], errs[i] = e.encode(g.rect(i))
+				if progress != nil {
+					mu.Lock()
+					done++
+					progress(float32(done) / float32(len(tiles)))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range tiles {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return tiles, errors.Join(errs...)
+}
+
+// decodeTile inflates and unfilters one tile, passing each of its rows to
+// fn.
+func decodeTile(h *header, r image.Rectangle, data []byte, fn rowFunc) error {
+	src := bytes.NewReader(data)
+	decompressor := newDecompressor(src, h.codec)
+	if h.filter == filterMethodLossy {
+		if err := decodeLossy(h, r, decompressor, fn); err != nil {
+			return err
+		}
+	} else {
+		f := h.format()
+		bpp := f.bytesPerPixel()
+		raw := make([]byte, 1+r.Dx()*bpp)
+		prev := make([]byte, r.Dx()*bpp)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			if _, err := io.ReadFull(decompressor, raw); err != nil {
+				return imageutil.NoEOF(err)
+			}
+			row := raw[1:]
+			if err := unfilter(raw[0], row, prev, bpp); err != nil {
+				return err
+			}
+			copy(prev, row)
+			if err := f.checkRow(row); err != nil {
+				return err
+			}
+			fn(y, r.Min.X, row)
+		}
+	}
+	if n, err := io.Copy(io.Discard, io.LimitReader(decompressor, 1)); err != nil {
+		return err
+	} else if n != 0 || src.Len() != 0 {
+		return errors.New("trailing pixel data")
+	}
+	return nil
+}
+
+// tileWorkers decodes tiles on a bounded number of goroutines, reporting
+// progress and keeping the first error.
+type tileWorkers struct {
+	sem      chan struct{}
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	err      error
+	done     int
+	total    int
+	progress func(float32)
+}
+
+func newTileWorkers(n, total int, progress func(float32)) *tileWorkers {
+	return &tileWorkers{sem: make(chan struct{}, concurrency(n)), total: total, progress: progress}
+}
+
+func (w *tileWorkers) fail(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+}
+
+func (w *tileWorkers) failed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err != nil
+}
+
+// decode inflates tile i of h in the background, once a goroutine is free.
+func (w *tileWorkers) decode(h *header, i int, data []byte, fn rowFunc) {
+	w.sem <- struct{}{}
+	w.wg.Add(1)
+	go func() {
+		defer func() { <-w.sem; w.wg.Done() }()
+		if err := decodeTile(h, h.grid().rect(i), data, fn); err != nil {
+			w.fail(damaged(SectionPixels, fmt.Errorf("tile %d: %w", i, err)))
+			return
+		}
+		if w.progress != nil {
+			w.mu.Lock()
+			w.done++
+			w.progress(float32(w.done) / float32(w.total))
+			w.mu.Unlock()
+		}
+	}()
+}
+
+// wait waits for every tile and returns the first error.
+func (w *tileWorkers) wait() error {
+	w.wg.Wait()
+	return w.err
+}
+
+// tileSource decodes the tiles that follow the INDX chunk, reading them
+// in order and inflating them on up to d.Concurrency goroutines.
+func tileSource(h *header, c *chunkReader, limits *Limits) pixelSource {
+	return func(d *Decoder, fn rowFunc) error {
+		g := h.grid()
+		w := newTileWorkers(d.Concurrency, g.count(), d.Progress)
+		for i := 0; i < g.count() && !w.failed(); i++ {
+			if i > 0 {
+				if err := c.next(); err != nil {
+					w.fail(err)
+					break
+				}
+			}
+			if c.typ != chunkData {
+				w.fail(damaged(SectionPixels, fmt.Errorf("missing DATA chunk for tile %d", i)))
+				break
+			}
+			if c.remaining != h.tileSizes[i] || c.remaining > h.maxTileSize(i) {
+				w.fail(damaged(SectionPixels, fmt.Errorf("tile %d does not match its index entry", i)))
+				break
+			}
+			data, err := c.body()
+			if err != nil {
+				w.fail(err)
+				break
+			}
+			w.decode(h, i, data, fn)
+		}
+		if err := w.wait(); err != nil {
+			return err
+		}
+
+		if g.count() > 0 {
+			if err := c.next(); err != nil {
+				return err
+			}
+		}
+		return h.readTrailer(c, limits)
+	}
+}
+
+// maxTileSize bounds the compressed size of tile i.
+func (h *header) maxTileSize(i int) uint32 {
+	r := h.grid().rect(i)
+	return maxPacked(r.Dy() * (1 + r.Dx()*h.format().bytesPerPixel()))
+}
+
+// readIndex parses the INDX chunk of a tiled file.
+func (h *header) readIndex(c *chunkReader) error {
+	n := h.grid().count()
+	if c.remaining != uint32(4*n) {
+		return damaged(chunkSection(chunkIndex), errors.New("wrong number of tiles"))
+	}
+	data, err := c.body()
+	if err != nil {
+		return err
+	}
+	h.tileSizes = make([]uint32, n)
+	for i := range h.tileSizes {
+		h.tileSizes[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+	return nil
+}
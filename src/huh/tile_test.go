@@ -0,0 +1,49 @@
+package huh
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestTiles(t *testing.T) {
+	img := testImage(image.Rect(0, 0, 45, 30))
+	for _, size := range []int{1, 7, 16, 45, 0} {
+		for _, n := range []int{1, 4} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, img, &Options{TileSize: size, Concurrency: n}); err != nil {
+				t.Fatalf("tile size %d: Encode: %v", size, err)
+			}
+			tileSize := size
+			if size == 0 {
+				tileSize = DefaultTileSize
+			}
+			g := tileGrid{width: 45, height: 30, tileW: tileSize, tileH: tileSize}
+			tiles := 0
+			for _, c := range splitChunks(t, buf.Bytes()) {
+				if c.typ == chunkData {
+					tiles++
+				}
+			}
+			if tiles != g.count() {
+				t.Errorf("tile size %d: %d DATA chunks, want %d", size, tiles, g.count())
+			}
+
+			for _, dn := range []int{1, 3} {
+				got, _, err := (&Decoder{Concurrency: dn}).Decode(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatalf("tile size %d: Decode: %v", size, err)
+				}
+				sameImage(t, "tiled", got, img)
+			}
+		}
+	}
+
+	// The same image encodes to the same bytes on any number of cores.
+	var one, many bytes.Buffer
+	Encode(&one, img, &Options{TileSize: 8, Concurrency: 1})
+	Encode(&many, img, &Options{TileSize: 8, Concurrency: 8})
+	if !bytes.Equal(one.Bytes(), many.Bytes()) {
+		t.Error("encoding depends on Concurrency")
+	}
+}
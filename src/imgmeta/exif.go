@@ -0,0 +1,240 @@
+package imgmeta
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"huh/src/huh"
+)
+
+// EXIF tags read by ParseEXIF.
+const (
+	tagImageDescription = 0x010e
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagSoftware         = 0x0131
+	tagArtist           = 0x013b
+	tagCopyright        = 0x8298
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829a
+	tagFNumber          = 0x829d
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagOffsetOriginal   = 0x9011
+	tagFocalLength      = 0x920a
+	tagLensModel        = 0xa434
+
+	tagGPSLatitudeRef  = 1
+	tagGPSLatitude     = 2
+	tagGPSLongitudeRef = 3
+	tagGPSLongitude    = 4
+	tagGPSAltitudeRef  = 5
+	tagGPSAltitude     = 6
+)
+
+// maxIFDEntries bounds the entries read from one IFD.
+const maxIFDEntries = 1024
+
+// typeSizes is the size in bytes of each TIFF field type, indexed by type.
+var typeSizes = [...]uint32{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+// tiff is an EXIF block: a TIFF header followed by its IFDs.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is one field of an IFD, with its value bytes resolved.
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+	order binary.ByteOrder
+}
+
+func (e ifdEntry) str() string {
+	if e.typ != 2 && e.typ != 7 {
+		return ""
+	}
+	s, _, _ := strings.Cut(string(e.value), "\x00")
+	return strings.TrimSpace(s)
+}
+
+// uint returns value i of a BYTE, SHORT or LONG field.
+func (e ifdEntry) uint(i int) (uint32, bool) {
+	if uint32(i) >= e.count {
+		return 0, false
+	}
+	switch e.typ {
+	case 1:
+		return uint32(e.value[i]), true
+	case 3:
+		return uint32(e.order.Uint16(e.value[2*i:])), true
+	case 4:
+		return e.order.Uint32(e.value[4*i:]), true
+	}
+	return 0, false
+}
+
+// rat returns value i of a RATIONAL or SRATIONAL field.
+func (e ifdEntry) rat(i int) (float64, bool) {
+	if uint32(i) >= e.count || (e.typ != 5 && e.typ != 10) {
+		return 0, false
+	}
+	num, den := e.order.Uint32(e.value[8*i:]), e.order.Uint32(e.value[8*i+4:])
+	if den == 0 {
+		return 0, false
+	}
+	if e.typ == 10 {
+		return float64(int32(num)) / float64(int32(den)), true
+	}
+	return float64(num) / float64(den), true
+}
+
+// ifd reads the IFD at off.
+func (t *tiff) ifd(off uint32) (map[uint16]ifdEntry, error) {
+	if uint64(off)+2 > uint64(len(t.data)) {
+		return nil, errors.New("EXIF IFD offset out of range")
+	}
+	n := uint32(t.order.Uint16(t.data[off:]))
+	if n > maxIFDEntries || uint64(off)+2+12*uint64(n) > uint64(len(t.data)) {
+		return nil, errors.New("EXIF IFD out of range")
+	}
+	entries := make(map[uint16]ifdEntry, n)
+	for i := uint32(0); i < n; i++ {
+		raw := t.data[off+2+12*i:]
+		e := ifdEntry{typ: t.order.Uint16(raw[2:]), count: t.order.Uint32(raw[4:]), order: t.order}
+		if int(e.typ) >= len(typeSizes) || e.typ == 0 {
+			continue
+		}
+		size := uint64(typeSizes[e.typ]) * uint64(e.count)
+		if size <= 4 {
+			e.value = raw[8 : 8+size]
+		} else {
+			at := uint64(t.order.Uint32(raw[8:]))
+			if at+size > uint64(len(t.data)) {
+				continue
+			}
+			e.value = t.data[at : at+size]
+		}
+		entries[t.order.Uint16(raw)] = e
+	}
+	return entries, nil
+}
+
+// ParseEXIF maps the fields of an EXIF block, a TIFF header and its IFDs
+// as found in a JPEG APP1 segment after "Exif\0\0" or a PNG eXIf chunk,
+// to HUH metadata: the camera, lens and exposure settings, the capture
+// time, orientation, GPS position, author and copyright.
+func ParseEXIF(data []byte) (huh.Metadata, error) {
+	t := &tiff{data: data}
+	switch {
+	case len(data) < 8:
+		return nil, errors.New("EXIF block too short")
+	case string(data[:4]) == "II*\x00":
+		t.order = binary.LittleEndian
+	case string(data[:4]) == "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid EXIF header")
+	}
+	ifd0, err := t.ifd(t.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := huh.Metadata{}
+	setString := func(ifd map[uint16]ifdEntry, tag uint16, key string) {
+		if s := ifd[tag].str(); s != "" {
+			meta[key] = s
+		}
+	}
+	setFloat := func(ifd map[uint16]ifdEntry, tag uint16, key string) {
+		if v, ok := ifd[tag].rat(0); ok && !math.IsInf(v, 0) {
+			meta[key] = v
+		}
+	}
+	setString(ifd0, tagImageDescription, huh.KeyDescription)
+	setString(ifd0, tagMake, huh.KeyCameraMake)
+	setString(ifd0, tagModel, huh.KeyCameraModel)
+	setString(ifd0, tagSoftware, huh.KeySoftware)
+	setString(ifd0, tagArtist, huh.KeyAuthor)
+	setString(ifd0, tagCopyright, huh.KeyCopyright)
+	if v, ok := ifd0[tagOrientation].uint(0); ok && v >= 1 && v <= 8 {
+		meta[huh.KeyOrientation] = int64(v)
+	}
+
+	if off, ok := ifd0[tagExifIFD].uint(0); ok {
+		if exif, err := t.ifd(off); err == nil {
+			setFloat(exif, tagExposureTime, huh.KeyExposureTime)
+			setFloat(exif, tagFNumber, huh.KeyFNumber)
+			setFloat(exif, tagFocalLength, huh.KeyFocalLength)
+			setString(exif, tagLensModel, huh.KeyLensModel)
+			if v, ok := exif[tagISO].uint(0); ok {
+				meta[huh.KeyISO] = int64(v)
+			}
+			if date, ok := exifTime(exif[tagDateTimeOriginal].str(), exif[tagOffsetOriginal].str()); ok {
+				meta[huh.KeyCreationDate] = date
+			}
+		}
+	}
+
+	if off, ok := ifd0[tagGPSIFD].uint(0); ok {
+		if gps, err := t.ifd(off); err == nil {
+			if pos := gpsPosition(gps); pos != nil {
+				meta[huh.KeyGPS] = pos
+			}
+		}
+	}
+	return meta, nil
+}
+
+// exifTime parses an EXIF date, which has no time zone unless the offset
+// tag gives one. Dates without one are taken as UTC.
+func exifTime(s, offset string) (time.Time, bool) {
+	const layout = "2006:01:02 15:04:05"
+	if offset != "" {
+		if t, err := time.Parse(layout+"-07:00", s+offset); err == nil {
+			return t, true
+		}
+	}
+	t, err := time.Parse(layout, s)
+	return t, err == nil
+}
+
+// gpsPosition reads the position in a GPS IFD, in decimal degrees and
+// meters.
+func gpsPosition(gps map[uint16]ifdEntry) huh.Metadata {
+	degrees := func(tag uint16, ref uint16, negative string) (float64, bool) {
+		d, ok1 := gps[tag].rat(0)
+		m, ok2 := gps[tag].rat(1)
+		s, ok3 := gps[tag].rat(2)
+		if !ok1 || !ok2 || !ok3 {
+			return 0, false
+		}
+		v := d + m/60 + s/3600
+		if gps[ref].str() == negative {
+			v = -v
+		}
+		return v, true
+	}
+	lat, ok1 := degrees(tagGPSLatitude, tagGPSLatitudeRef, "S")
+	lon, ok2 := degrees(tagGPSLongitude, tagGPSLongitudeRef, "W")
+	if !ok1 || !ok2 || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return nil
+	}
+	pos := huh.Metadata{"latitude": lat, "longitude": lon}
+	if alt, ok := gps[tagGPSAltitude].rat(0); ok {
+		// Altitude ref 1 means below sea level.
+		if ref, _ := gps[tagGPSAltitudeRef].uint(0); ref == 1 {
+			alt = -alt
+		}
+		pos["altitude"] = alt
+	}
+	return pos
+}
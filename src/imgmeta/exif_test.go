@@ -0,0 +1,315 @@
+package imgmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"huh/src/huh"
+)
+
+// byteOrder is a byte order that can also append.
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffField is one field of an IFD built by buildTIFF. A positive ifd
+// makes it a LONG pointing to that IFD instead.
+type tiffField struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+	ifd   int
+}
+
+// buildTIFF lays out an EXIF block: the header, ifds in order with the
+// first as IFD0, and then every value that does not fit in its entry.
+func buildTIFF(order byteOrder, ifds ...[]tiffField) []byte {
+	offsets := make([]uint32, len(ifds))
+	end := uint32(8)
+	for i, ifd := range ifds {
+		offsets[i] = end
+		end += 2 + 12*uint32(len(ifd)) + 4
+	}
+	data := make([]byte, 8, end)
+	if order == binary.LittleEndian {
+		copy(data, "II*\x00")
+	} else {
+		copy(data, "MM\x00*")
+	}
+	order.PutUint32(data[4:], offsets[0])
+	var values []byte
+	for _, ifd := range ifds {
+		data = order.AppendUint16(data, uint16(len(ifd)))
+		for _, f := range ifd {
+			entry := make([]byte, 12)
+			order.PutUint16(entry, f.tag)
+			if f.ifd > 0 {
+				order.PutUint16(entry[2:], 4)
+				order.PutUint32(entry[4:], 1)
+				order.PutUint32(entry[8:], offsets[f.ifd])
+			} else {
+				order.PutUint16(entry[2:], f.typ)
+				order.PutUint32(entry[4:], f.count)
+				if len(f.value) <= 4 {
+					copy(entry[8:], f.value)
+				} else {
+					order.PutUint32(entry[8:], end+uint32(len(values)))
+					values = append(values, f.value...)
+				}
+			}
+			data = append(data, entry...)
+		}
+		data = order.AppendUint32(data, 0)
+	}
+	return append(data, values...)
+}
+
+func asciiField(tag uint16, s string) tiffField {
+	return tiffField{tag: tag, typ: 2, count: uint32(len(s) + 1), value: []byte(s + "\x00")}
+}
+
+func shortField(order byteOrder, tag, v uint16) tiffField {
+	return tiffField{tag: tag, typ: 3, count: 1, value: order.AppendUint16(nil, v)}
+}
+
+func byteField(tag uint16, v byte) tiffField {
+	return tiffField{tag: tag, typ: 1, count: 1, value: []byte{v}}
+}
+
+// ratField holds the rationals num/den given as pairs.
+func ratField(order byteOrder, tag uint16, pairs ...uint32) tiffField {
+	var value []byte
+	for _, v := range pairs {
+		value = order.AppendUint32(value, v)
+	}
+	return tiffField{tag: tag, typ: 5, count: uint32(len(pairs) / 2), value: value}
+}
+
+// sameMetadata reports whether a and b hold the same values, with floats
+// allowed to differ by rounding.
+func sameMetadata(a, b huh.Metadata) bool {
+	if len(a) != len(b) || (a == nil) != (b == nil) {
+		return false
+	}
+	for k, v := range a {
+		switch v := v.(type) {
+		case float64:
+			w, ok := b[k].(float64)
+			if !ok || math.Abs(v-w) > 1e-9 {
+				return false
+			}
+		case huh.Metadata:
+			w, ok := b[k].(huh.Metadata)
+			if !ok || !sameMetadata(v, w) {
+				return false
+			}
+		default:
+			if !reflect.DeepEqual(v, b[k]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestParseEXIF(t *testing.T) {
+	for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+		ifd0 := []tiffField{
+			asciiField(tagMake, "Acme"),
+			asciiField(tagModel, "Snapper 3000"),
+			shortField(order, tagOrientation, 6),
+			asciiField(tagArtist, "  Jane Doe  "),
+			asciiField(tagCopyright, "CC0"),
+			{tag: tagExifIFD, ifd: 1},
+			{tag: tagGPSIFD, ifd: 2},
+		}
+		exif := []tiffField{
+			ratField(order, tagExposureTime, 1, 250),
+			ratField(order, tagFNumber, 28, 10),
+			shortField(order, tagISO, 400),
+			ratField(order, tagFocalLength, 50, 1),
+			asciiField(tagDateTimeOriginal, "2024:05:06 07:08:09"),
+			asciiField(tagOffsetOriginal, "+02:00"),
+			asciiField(tagLensModel, "50mm f/1.8"),
+		}
+		gps := []tiffField{
+			asciiField(tagGPSLatitudeRef, "N"),
+			ratField(order, tagGPSLatitude, 48, 1, 51, 1, 2952, 100),
+			asciiField(tagGPSLongitudeRef, "W"),
+			ratField(order, tagGPSLongitude, 2, 1, 21, 1, 0, 1),
+			byteField(tagGPSAltitudeRef, 1),
+			ratField(order, tagGPSAltitude, 3550, 100),
+		}
+		meta, err := ParseEXIF(buildTIFF(order, ifd0, exif, gps))
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+
+		date, _ := meta[huh.KeyCreationDate].(time.Time)
+		if want := time.Date(2024, 5, 6, 5, 8, 9, 0, time.UTC); !date.Equal(want) {
+			t.Errorf("%v: creation date %v, want %v", order, meta[huh.KeyCreationDate], want)
+		}
+		delete(meta, huh.KeyCreationDate)
+		want := huh.Metadata{
+			huh.KeyCameraMake:   "Acme",
+			huh.KeyCameraModel:  "Snapper 3000",
+			huh.KeyOrientation:  int64(6),
+			huh.KeyAuthor:       "Jane Doe",
+			huh.KeyCopyright:    "CC0",
+			huh.KeyExposureTime: 1.0 / 250,
+			huh.KeyFNumber:      2.8,
+			huh.KeyISO:          int64(400),
+			huh.KeyFocalLength:  50.0,
+			huh.KeyLensModel:    "50mm f/1.8",
+			huh.KeyGPS: huh.Metadata{
+				"latitude":  48 + 51.0/60 + 29.52/3600,
+				"longitude": -(2 + 21.0/60),
+				"altitude":  -35.5,
+			},
+		}
+		if !sameMetadata(meta, want) {
+			t.Errorf("%v: got\n%v\nwant\n%v", order, meta, want)
+		}
+	}
+}
+
+func TestParseEXIFGPS(t *testing.T) {
+	o := binary.LittleEndian
+	tests := []struct {
+		name string
+		gps  []tiffField
+		want huh.Metadata
+	}{
+		{"south east", []tiffField{
+			asciiField(tagGPSLatitudeRef, "S"),
+			ratField(o, tagGPSLatitude, 33, 1, 52, 1, 0, 1),
+			asciiField(tagGPSLongitudeRef, "E"),
+			ratField(o, tagGPSLongitude, 151, 1, 12, 1, 36, 1),
+		}, huh.Metadata{"latitude": -(33 + 52.0/60), "longitude": 151 + 12.0/60 + 36.0/3600}},
+		{"fractional minutes", []tiffField{
+			ratField(o, tagGPSLatitude, 10, 1, 3075, 100, 0, 1),
+			ratField(o, tagGPSLongitude, 20, 1, 0, 1, 0, 1),
+			ratField(o, tagGPSAltitude, 1200, 1),
+		}, huh.Metadata{"latitude": 10 + 30.75/60, "longitude": 20.0, "altitude": 1200.0}},
+		{"zero denominator", []tiffField{
+			ratField(o, tagGPSLatitude, 10, 0, 0, 1, 0, 1),
+			ratField(o, tagGPSLongitude, 20, 1, 0, 1, 0, 1),
+		}, nil},
+		{"latitude out of range", []tiffField{
+			ratField(o, tagGPSLatitude, 91, 1, 0, 1, 0, 1),
+			ratField(o, tagGPSLongitude, 20, 1, 0, 1, 0, 1),
+		}, nil},
+		{"missing seconds", []tiffField{
+			ratField(o, tagGPSLatitude, 10, 1, 0, 1),
+			ratField(o, tagGPSLongitude, 20, 1, 0, 1, 0, 1),
+		}, nil},
+		{"not rationals", []tiffField{
+			{tag: tagGPSLatitude, typ: 4, count: 3, value: make([]byte, 12)},
+			ratField(o, tagGPSLongitude, 20, 1, 0, 1, 0, 1),
+		}, nil},
+	}
+	for _, tt := range tests {
+		meta, err := ParseEXIF(buildTIFF(o, []tiffField{{tag: tagGPSIFD, ifd: 1}}, tt.gps))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, _ := meta[huh.KeyGPS].(huh.Metadata)
+		if !sameMetadata(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseEXIFOutOfRange(t *testing.T) {
+	o := binary.BigEndian
+	valid := buildTIFF(o, []tiffField{asciiField(tagModel, "Long enough to be stored after the IFD")})
+	// The value offset of the first entry, after the header, count, tag,
+	// type and count.
+	const valueOffset = 8 + 2 + 8
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+		want    huh.Metadata
+	}{
+		{"empty", nil, "too short", nil},
+		{"short", []byte("II*\x00\x08\x00"), "too short", nil},
+		{"bad magic", []byte("II+\x00\x08\x00\x00\x00"), "invalid EXIF header", nil},
+		{"IFD0 past the end", func() []byte {
+			d := bytes.Clone(valid)
+			o.PutUint32(d[4:], uint32(len(d)))
+			return d
+		}(), "offset out of range", nil},
+		{"IFD0 offset overflows", func() []byte {
+			d := bytes.Clone(valid)
+			o.PutUint32(d[4:], 0xffffffff)
+			return d
+		}(), "offset out of range", nil},
+		{"entries past the end", func() []byte {
+			d := bytes.Clone(valid)
+			o.PutUint16(d[8:], 100)
+			return d
+		}(), "IFD out of range", nil},
+		{"too many entries", func() []byte {
+			d := append(bytes.Clone(valid), make([]byte, 12*(maxIFDEntries+1))...)
+			o.PutUint16(d[8:], maxIFDEntries+1)
+			return d
+		}(), "IFD out of range", nil},
+		{"value past the end", func() []byte {
+			d := bytes.Clone(valid)
+			o.PutUint32(d[valueOffset:], uint32(len(d)-4))
+			return d
+		}(), "", huh.Metadata{}},
+		{"value offset overflows", func() []byte {
+			d := bytes.Clone(valid)
+			o.PutUint32(d[valueOffset:], 0xfffffff0)
+			return d
+		}(), "", huh.Metadata{}},
+		{"value count overflows", func() []byte {
+			d := bytes.Clone(valid)
+			o.PutUint32(d[valueOffset-4:], 0xffffffff)
+			return d
+		}(), "", huh.Metadata{}},
+		{"unknown type", func() []byte {
+			d := bytes.Clone(valid)
+			o.PutUint16(d[valueOffset-6:], 99)
+			return d
+		}(), "", huh.Metadata{}},
+		{"sub-IFDs past the end", buildTIFF(o, []tiffField{
+			asciiField(tagMake, "Acme"),
+			{tag: tagExifIFD, typ: 4, count: 1, value: o.AppendUint32(nil, 1<<20)},
+			{tag: tagGPSIFD, typ: 4, count: 1, value: o.AppendUint32(nil, 0xffffffff)},
+		}), "", huh.Metadata{huh.KeyCameraMake: "Acme"}},
+	}
+	for _, tt := range tests {
+		meta, err := ParseEXIF(tt.data)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !reflect.DeepEqual(meta, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, meta, tt.want)
+		}
+	}
+
+	// No damage to any byte may panic.
+	for i := range valid {
+		for _, b := range []byte{0, 0x7f, 0xff} {
+			d := bytes.Clone(valid)
+			d[i] = b
+			ParseEXIF(d)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// Package imgmeta reads the metadata embedded in JPEG and PNG files, EXIF,
+// XMP, text chunks and comments and ICC color profiles, and maps it to HUH
+// metadata. Embed writes HUH metadata back into such files.
+package imgmeta
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"huh/src/huh"
+)
+
+// Info is the metadata found in an image file.
+type Info struct {
+	// Metadata holds every field that maps to HUH metadata.
+	Metadata huh.Metadata
+	// ICCProfile is the embedded color profile, or nil.
+	ICCProfile []byte
+}
+
+// maxBlock bounds the size of a metadata block held in memory, including
+// decompressed PNG text and profiles.
+const maxBlock = 16 << 20
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+	xmpHeader    = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader    = []byte("ICC_PROFILE\x00")
+)
+
+// xmpKeyword is the keyword of the PNG iTXt chunk holding an XMP packet.
+const xmpKeyword = "XML:com.adobe.xmp"
+
+// pngKeywords maps the predefined PNG text keywords to HUH metadata keys.
+var pngKeywords = map[string]string{
+	"Title":         huh.KeyTitle,
+	"Author":        huh.KeyAuthor,
+	"Description":   huh.KeyDescription,
+	"Copyright":     huh.KeyCopyright,
+	"Creation Time": huh.KeyCreationDate,
+	"Software":      huh.KeySoftware,
+	"Source":        huh.KeyCameraModel,
+	"Comment":       huh.KeyComment,
+}
+
+// Read reads the metadata of the JPEG or PNG file in r, stopping before
+// the pixel data of JPEGs. Other formats give an empty Info. Damaged EXIF
+// and XMP blocks are skipped, since the image itself may well be fine; an
+// error means the file structure could not be read, and the Info holds
+// what was found up to there.
+func Read(r io.Reader) (*Info, error) {
+	br := bufio.NewReader(r)
+	sig, _ := br.Peek(len(pngSignature))
+	c := &collector{text: huh.Metadata{}, iccParts: map[byte][]byte{}}
+	var err error
+	switch {
+	case bytes.HasPrefix(sig, []byte{0xff, 0xd8}):
+		err = readJPEG(br, c)
+	case bytes.Equal(sig, pngSignature):
+		err = readPNG(br, c)
+	}
+	return c.info(), err
+}
+
+// collector gathers the metadata blocks of a file, to be merged once all
+// of them are known.
+type collector struct {
+	exif, xmp []byte
+	text      huh.Metadata
+	icc       []byte
+	// iccParts holds the ICC profile of a JPEG, which is split over APP2
+	// segments, by sequence number.
+	iccParts map[byte][]byte
+	iccCount byte
+}
+
+// info merges what c has found. EXIF wins over XMP, which wins over text
+// chunks and comments.
+func (c *collector) info() *Info {
+	info := &Info{Metadata: c.text, ICCProfile: c.icc}
+	if c.xmp != nil {
+		if meta, err := ParseXMP(c.xmp); err == nil {
+			for k, v := range meta {
+				info.Metadata[k] = v
+			}
+		}
+		info.Metadata[huh.KeyXMP] = string(c.xmp)
+	}
+	if c.exif != nil {
+		if meta, err := ParseEXIF(c.exif); err == nil {
+			for k, v := range meta {
+				info.Metadata[k] = v
+			}
+		}
+	}
+	if info.ICCProfile == nil && c.iccCount > 0 && len(c.iccParts) == int(c.iccCount) {
+		seqs := make([]int, 0, len(c.iccParts))
+		for seq := range c.iccParts {
+			seqs = append(seqs, int(seq))
+		}
+		sort.Ints(seqs)
+		if seqs[0] == 1 && seqs[len(seqs)-1] == int(c.iccCount) {
+			for _, seq := range seqs {
+				info.ICCProfile = append(info.ICCProfile, c.iccParts[byte(seq)]...)
+			}
+		}
+	}
+	return info
+}
+
+// readJPEG reads the segments of a JPEG up to its first scan, which every
+// metadata segment precedes.
+func readJPEG(br *bufio.Reader, c *collector) error {
+	br.Discard(2)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != 0xff {
+			return errors.New("invalid JPEG marker")
+		}
+		// Markers may be padded with any number of 0xff bytes.
+		marker := byte(0xff)
+		for marker == 0xff {
+			if marker, err = br.ReadByte(); err != nil {
+				return err
+			}
+		}
+		switch {
+		case marker >= 0xd0 && marker <= 0xd7, marker == 0x01:
+			continue
+		case marker == 0xd9, marker == 0xda:
+			return nil
+		}
+
+		var length uint16
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if length < 2 {
+			return errors.New("invalid JPEG segment length")
+		}
+		if marker != 0xe1 && marker != 0xe2 && marker != 0xfe {
+			if _, err := br.Discard(int(length) - 2); err != nil {
+				return err
+			}
+			continue
+		}
+		data := make([]byte, length-2)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return err
+		}
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(data, exifHeader):
+			c.exif = data[len(exifHeader):]
+		case marker == 0xe1 && bytes.HasPrefix(data, xmpHeader):
+			c.xmp = data[len(xmpHeader):]
+		case marker == 0xe2 && bytes.HasPrefix(data, iccHeader) && len(data) >= len(iccHeader)+2:
+			seq, count := data[len(iccHeader)], data[len(iccHeader)+1]
+			c.iccParts[seq] = data[len(iccHeader)+2:]
+			c.iccCount = count
+		case marker == 0xfe:
+			if s := strings.TrimRight(latin1(data), "\x00"); s != "" {
+				c.text[huh.KeyComment] = s
+			}
+		}
+	}
+}
+
+// readPNG reads the chunks of a PNG, skipping over the image data, since
+// text chunks may also follow it.
+func readPNG(br *bufio.Reader, c *collector) error {
+	br.Discard(len(pngSignature))
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(hdr[:4]))
+		typ := string(hdr[4:])
+		if typ == "IEND" {
+			return nil
+		}
+		switch typ {
+		case "eXIf", "iCCP", "tEXt", "zTXt", "iTXt":
+			if length <= maxBlock {
+				data := make([]byte, length)
+				if _, err := io.ReadFull(br, data); err != nil {
+					return err
+				}
+				c.pngChunk(typ, data)
+				length = 0
+			}
+		}
+		// The CRCs are left to the image decoder.
+		if _, err := io.CopyN(io.Discard, br, length+4); err != nil {
+			return err
+		}
+	}
+}
+
+// pngChunk handles one metadata chunk of a PNG. Malformed chunks are
+// skipped.
+func (c *collector) pngChunk(typ string, data []byte) {
+	if typ == "eXIf" {
+		c.exif = data
+		return
+	}
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return
+	}
+	var text string
+	switch typ {
+	case "iCCP":
+		if len(rest) > 0 && rest[0] == 0 {
+			if profile, err := inflate(rest[1:]); err == nil {
+				c.icc = profile
+			}
+		}
+		return
+	case "tEXt":
+		text = latin1(rest)
+	case "zTXt":
+		if len(rest) == 0 || rest[0] != 0 {
+			return
+		}
+		s, err := inflate(rest[1:])
+		if err != nil {
+			return
+		}
+		text = latin1(s)
+	case "iTXt":
+		// Compression flag and method, then language and translated
+		// keyword, each ending in a NUL byte.
+		if len(rest) < 2 {
+			return
+		}
+		compressed := rest[0] == 1
+		fields := bytes.SplitN(rest[2:], []byte{0}, 3)
+		if len(fields) != 3 {
+			return
+		}
+		s := fields[2]
+		if compressed {
+			var err error
+			if s, err = inflate(s); err != nil {
+				return
+			}
+		}
+		if string(keyword) == xmpKeyword {
+			c.xmp = s
+			return
+		}
+		text = string(s)
+	}
+	c.setText(latin1(keyword), text)
+}
+
+// setText stores a PNG text chunk. The predefined keywords map to their
+// HUH keys; other keywords are kept as they are, with the type of the key
+// if it is a well-known one.
+func (c *collector) setText(keyword, text string) {
+	if text == "" {
+		return
+	}
+	key, ok := pngKeywords[keyword]
+	if !ok {
+		key = keyword
+	}
+	if key == huh.KeyCreationDate {
+		// PNG suggests RFC 1123 dates, but anything goes.
+		for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339Nano} {
+			if t, err := time.Parse(layout, text); err == nil {
+				c.text[key] = t
+				return
+			}
+		}
+		if t, ok := xmpTime(text); ok {
+			c.text[key] = t
+		}
+		return
+	}
+	if v, err := huh.ParseValue(key, "", text); err == nil {
+		c.text[key] = v
+	}
+}
+
+// inflate decompresses a zlib stream of at most maxBlock bytes.
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(zr, maxBlock+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxBlock {
+		return nil, fmt.Errorf("metadata block exceeds %d bytes", maxBlock)
+	}
+	return out, nil
+}
+
+// latin1 converts the ISO 8859-1 text of PNG tEXt chunks to UTF-8. Text
+// that already is valid UTF-8 is kept as is, since many encoders write it
+// regardless.
+func latin1(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
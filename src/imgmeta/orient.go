@@ -0,0 +1,75 @@
+package imgmeta
+
+import (
+	"image"
+	"image/color"
+)
+
+// Orient returns img turned upright according to an EXIF orientation:
+// 2 and 4 mirror it horizontally and vertically, 3 turns it half around,
+// 6 and 8 turn it a quarter clockwise and counterclockwise, and 5 and 7
+// mirror it across its diagonals. Other values return img unchanged. The
+// result keeps the color model of gray, 16-bit and palette images, and is
+// NRGBA otherwise.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if orientation >= 5 {
+		dw, dh = sh, sw
+	}
+	// src maps a pixel of the result to the pixel of img it comes from.
+	src := func(x, y int) (int, int) {
+		switch orientation {
+		case 2:
+			return sw - 1 - x, y
+		case 3:
+			return sw - 1 - x, sh - 1 - y
+		case 4:
+			return x, sh - 1 - y
+		case 5:
+			return y, x
+		case 6:
+			return y, sh - 1 - x
+		case 7:
+			return sw - 1 - y, sh - 1 - x
+		}
+		return sw - 1 - y, x
+	}
+
+	rect := image.Rect(0, 0, dw, dh)
+	if p, ok := img.(*image.Paletted); ok {
+		dst := image.NewPaletted(rect, p.Palette)
+		for y := 0; y < dh; y++ {
+			for x := 0; x < dw; x++ {
+				sx, sy := src(x, y)
+				dst.SetColorIndex(x, y, p.ColorIndexAt(b.Min.X+sx, b.Min.Y+sy))
+			}
+		}
+		return dst
+	}
+	var dst interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	switch img.ColorModel() {
+	case color.GrayModel:
+		dst = image.NewGray(rect)
+	case color.Gray16Model:
+		dst = image.NewGray16(rect)
+	case color.RGBA64Model, color.NRGBA64Model:
+		dst = image.NewNRGBA64(rect)
+	default:
+		dst = image.NewNRGBA(rect)
+	}
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := src(x, y)
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
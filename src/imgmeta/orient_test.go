@@ -0,0 +1,52 @@
+package imgmeta
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+	const sw, sh = 3, 2
+	src := image.NewGray(image.Rect(10, 20, 10+sw, 20+sh))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i + 1)
+	}
+	// dest gives where pixel (x, y) of the stored image ends up once it is
+	// upright.
+	dest := map[int]func(x, y int) (int, int){
+		2: func(x, y int) (int, int) { return sw - 1 - x, y },
+		3: func(x, y int) (int, int) { return sw - 1 - x, sh - 1 - y },
+		4: func(x, y int) (int, int) { return x, sh - 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return sh - 1 - y, x },
+		7: func(x, y int) (int, int) { return sh - 1 - y, sw - 1 - x },
+		8: func(x, y int) (int, int) { return y, sw - 1 - x },
+	}
+	for orientation, fn := range dest {
+		got := Orient(src, orientation)
+		if got.ColorModel() != color.GrayModel {
+			t.Errorf("orientation %d: color model %v", orientation, got.ColorModel())
+		}
+		for y := 0; y < sh; y++ {
+			for x := 0; x < sw; x++ {
+				dx, dy := fn(x, y)
+				if g, w := got.At(dx, dy), src.At(10+x, 20+y); g != w {
+					t.Errorf("orientation %d: pixel (%d, %d) is %v, want %v", orientation, dx, dy, g, w)
+				}
+			}
+		}
+	}
+
+	for _, orientation := range []int{0, 1, 9} {
+		if Orient(src, orientation) != image.Image(src) {
+			t.Errorf("orientation %d changed the image", orientation)
+		}
+	}
+	p := image.NewPaletted(image.Rect(0, 0, 2, 1), color.Palette{color.Black, color.White})
+	p.Pix[1] = 1
+	got, ok := Orient(p, 6).(*image.Paletted)
+	if !ok || got.Bounds() != image.Rect(0, 0, 1, 2) || got.ColorIndexAt(0, 1) != 1 {
+		t.Errorf("palette image turned into %T %v", Orient(p, 6), Orient(p, 6).Bounds())
+	}
+}
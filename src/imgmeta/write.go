@@ -0,0 +1,274 @@
+package imgmeta
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+
+	"huh/src/huh"
+)
+
+// maxSegment is the largest payload of a JPEG segment.
+const maxSegment = 65533
+
+// Embed writes the PNG or JPEG file in data to w with the metadata and ICC
+// profile of info added, as written by image/png and image/jpeg, which
+// leave them out. PNGs get a tEXt chunk per key, or iTXt for text outside
+// Latin-1, and an iCCP chunk. JPEGs get an XMP packet in APP1, the
+// comment in a COM segment and the profile in APP2 segments. The stored
+// XMP packet of imported files is not written back, since the metadata
+// may have changed since; the packet is rebuilt from the metadata instead.
+func Embed(w io.Writer, data []byte, info *Info) error {
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		return embedPNG(w, data, info)
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return embedJPEG(w, data, info)
+	}
+	return errors.New("metadata can only be embedded in PNG and JPEG files")
+}
+
+func embedPNG(w io.Writer, data []byte, info *Info) error {
+	// IHDR always comes first, and iCCP must precede the image data, so
+	// everything goes right after it.
+	ihdr := len(pngSignature) + 8 + 13 + 4
+	if len(data) < ihdr || string(data[len(pngSignature)+4:len(pngSignature)+8]) != "IHDR" {
+		return errors.New("invalid PNG file")
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:ihdr])
+	if info.ICCProfile != nil {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(info.ICCProfile)
+		zw.Close()
+		writePNGChunk(&buf, "iCCP", append([]byte("ICC profile\x00\x00"), z.Bytes()...))
+	}
+	keywords := make(map[string]string, len(pngKeywords))
+	for keyword, key := range pngKeywords {
+		keywords[key] = keyword
+	}
+	for _, key := range sortedKeys(info.Metadata) {
+		if key == huh.KeyXMP {
+			continue
+		}
+		text, err := huh.FormatValue(info.Metadata[key])
+		if err != nil {
+			return err
+		}
+		keyword := key
+		if k, ok := keywords[key]; ok {
+			keyword = k
+		}
+		if !validKeyword(keyword) {
+			continue
+		}
+		if isLatin1(text) {
+			writePNGChunk(&buf, "tEXt", append([]byte(keyword+"\x00"), toLatin1(text)...))
+		} else {
+			// No compression, no language and no translated keyword.
+			writePNGChunk(&buf, "iTXt", append([]byte(keyword+"\x00\x00\x00\x00\x00"), text...))
+		}
+	}
+	buf.Write(data[ihdr:])
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writePNGChunk(w *bytes.Buffer, typ string, data []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(data)))
+	w.WriteString(typ)
+	w.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// validKeyword reports whether s can be the keyword of a PNG text chunk:
+// 1 to 79 printable Latin-1 characters without leading, trailing or
+// repeated spaces.
+func validKeyword(s string) bool {
+	if len(s) == 0 || len(s) > 79 || s[0] == ' ' || s[len(s)-1] == ' ' || strings.Contains(s, "  ") {
+		return false
+	}
+	for _, c := range s {
+		if c < 0x20 || (c > 0x7e && c < 0xa1) || c > 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+func isLatin1(s string) bool {
+	for _, c := range s {
+		if c > 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+func toLatin1(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, c := range s {
+		b = append(b, byte(c))
+	}
+	return b
+}
+
+func embedJPEG(w io.Writer, data []byte, info *Info) error {
+	// After SOI, and after a JFIF APP0 segment, which must come first.
+	at := 2
+	if len(data) >= 6 && data[2] == 0xff && data[3] == 0xe0 {
+		at = 4 + int(binary.BigEndian.Uint16(data[4:]))
+		if at > len(data) {
+			return errors.New("invalid JPEG file")
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:at])
+	if packet := buildXMP(info.Metadata); packet != nil && len(xmpHeader)+len(packet) <= maxSegment {
+		writeSegment(&buf, 0xe1, append(append([]byte(nil), xmpHeader...), packet...))
+	}
+	if profile := info.ICCProfile; profile != nil {
+		const part = maxSegment - 14
+		count := (len(profile) + part - 1) / part
+		if count <= 255 {
+			for i := 0; i < count; i++ {
+				seg := append(append([]byte(nil), iccHeader...), byte(i+1), byte(count))
+				seg = append(seg, profile[i*part:min((i+1)*part, len(profile))]...)
+				writeSegment(&buf, 0xe2, seg)
+			}
+		}
+	}
+	if comment, ok := info.Metadata[huh.KeyComment].(string); ok && len(comment) <= maxSegment {
+		writeSegment(&buf, 0xfe, []byte(comment))
+	}
+	buf.Write(data[at:])
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeSegment(w *bytes.Buffer, marker byte, data []byte) {
+	w.Write([]byte{0xff, marker})
+	binary.Write(w, binary.BigEndian, uint16(len(data)+2))
+	w.Write(data)
+}
+
+// buildXMP writes meta as an XMP packet. Well-known keys become their
+// Dublin Core, XMP basic, TIFF or EXIF aux properties, and every other
+// key, including the well-known ones without a standard property, a
+// property of the huh namespace, which ParseXMP maps back. It returns nil
+// if there is nothing to write.
+func buildXMP(meta huh.Metadata) []byte {
+	var props bytes.Buffer
+	for _, key := range sortedKeys(meta) {
+		v := meta[key]
+		switch key {
+		case huh.KeyXMP, huh.KeyComment:
+		case huh.KeyTitle, huh.KeyDescription, huh.KeyCopyright:
+			name := map[string]string{huh.KeyTitle: "dc:title", huh.KeyDescription: "dc:description", huh.KeyCopyright: "dc:rights"}[key]
+			if s, ok := v.(string); ok {
+				writeXMPList(&props, name, "Alt", []string{s})
+			}
+		case huh.KeyAuthor:
+			if s, ok := v.(string); ok {
+				writeXMPList(&props, "dc:creator", "Seq", []string{s})
+			}
+		case huh.KeyKeywords:
+			var items []string
+			if list, ok := v.([]any); ok {
+				for _, item := range list {
+					if s, ok := item.(string); ok {
+						items = append(items, s)
+					}
+				}
+			}
+			writeXMPList(&props, "dc:subject", "Bag", items)
+		case huh.KeySoftware, huh.KeyCameraMake, huh.KeyCameraModel, huh.KeyLensModel, huh.KeyCreationDate, huh.KeyOrientation:
+			name := map[string]string{
+				huh.KeySoftware:     "xmp:CreatorTool",
+				huh.KeyCameraMake:   "tiff:Make",
+				huh.KeyCameraModel:  "tiff:Model",
+				huh.KeyLensModel:    "aux:Lens",
+				huh.KeyCreationDate: "xmp:CreateDate",
+				huh.KeyOrientation:  "tiff:Orientation",
+			}[key]
+			text, err := huh.FormatValue(v)
+			if err == nil {
+				writeXMPProperty(&props, name, text)
+			}
+		default:
+			text, err := huh.FormatValue(v)
+			if err == nil && validXMLName(key) {
+				writeXMPProperty(&props, "huh:"+key, text)
+			}
+		}
+	}
+	if props.Len() == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	buf.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="` + nsRDF + `">`)
+	buf.WriteString(`<rdf:Description rdf:about="" xmlns:dc="` + nsDC + `" xmlns:xmp="` + nsXMP +
+		`" xmlns:tiff="` + nsTIFF + `" xmlns:aux="` + nsAux + `" xmlns:huh="` + nsHUH + `">`)
+	buf.Write(props.Bytes())
+	buf.WriteString("</rdf:Description></rdf:RDF></x:xmpmeta>\n<?xpacket end=\"w\"?>")
+	return buf.Bytes()
+}
+
+func writeXMPProperty(w *bytes.Buffer, name, text string) {
+	w.WriteString("<" + name + ">")
+	xml.EscapeText(w, []byte(text))
+	w.WriteString("</" + name + ">")
+}
+
+// writeXMPList writes a property holding an RDF container of items.
+func writeXMPList(w *bytes.Buffer, name, container string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	w.WriteString("<" + name + "><rdf:" + container + ">")
+	for _, item := range items {
+		if container == "Alt" {
+			w.WriteString(`<rdf:li xml:lang="x-default">`)
+		} else {
+			w.WriteString("<rdf:li>")
+		}
+		xml.EscapeText(w, []byte(item))
+		w.WriteString("</rdf:li>")
+	}
+	w.WriteString("</rdf:" + container + "></" + name + ">")
+}
+
+// validXMLName reports whether key can be the local name of an XML
+// element.
+func validXMLName(key string) bool {
+	for i, c := range key {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c == '-' || c == '.' || (c >= '0' && c <= '9')):
+		default:
+			return false
+		}
+	}
+	return key != "" && !strings.HasPrefix(strings.ToLower(key), "xml")
+}
+
+func sortedKeys(meta huh.Metadata) []string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
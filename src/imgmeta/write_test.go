@@ -0,0 +1,57 @@
+package imgmeta
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+	"time"
+
+	"huh/src/huh"
+)
+
+func TestEmbed(t *testing.T) {
+	meta := huh.Metadata{
+		huh.KeyTitle:        "Beach",
+		huh.KeyAuthor:       "Zoë",
+		huh.KeyComment:      "first swim",
+		huh.KeyCreationDate: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		huh.KeyKeywords:     []any{"sea", "sand"},
+		huh.KeyISO:          int64(200),
+	}
+	info := &Info{Metadata: meta, ICCProfile: bytes.Repeat([]byte("icc"), 40000)}
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+
+	for name, encode := range map[string]func(*bytes.Buffer) error{
+		"PNG":  func(b *bytes.Buffer) error { return png.Encode(b, img) },
+		"JPEG": func(b *bytes.Buffer) error { return jpeg.Encode(b, img, nil) },
+	} {
+		var plain, out bytes.Buffer
+		if err := encode(&plain); err != nil {
+			t.Fatal(err)
+		}
+		if err := Embed(&out, plain.Bytes(), info); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, _, err := image.Decode(bytes.NewReader(out.Bytes())); err != nil {
+			t.Errorf("%s: embedded file does not decode: %v", name, err)
+		}
+		got, err := Read(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got.ICCProfile, info.ICCProfile) {
+			t.Errorf("%s: ICC profile of %d bytes, want %d", name, len(got.ICCProfile), len(info.ICCProfile))
+		}
+		delete(got.Metadata, huh.KeyXMP)
+		if !reflect.DeepEqual(got.Metadata, meta) {
+			t.Errorf("%s: got %v, want %v", name, got.Metadata, meta)
+		}
+	}
+
+	if err := Embed(new(bytes.Buffer), []byte("GIF89a"), info); err == nil {
+		t.Error("GIF accepted")
+	}
+}
@@ -0,0 +1,181 @@
+package imgmeta
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"huh/src/huh"
+)
+
+// XML namespaces of the XMP properties read by ParseXMP.
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsTIFF      = "http://ns.adobe.com/tiff/1.0/"
+	nsEXIF      = "http://ns.adobe.com/exif/1.0/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsAux       = "http://ns.adobe.com/exif/1.0/aux/"
+	// nsHUH holds the metadata keys without a standard XMP property, as
+	// written by Embed.
+	nsHUH = "urn:huh:metadata"
+)
+
+// xmpKeys maps XMP properties to HUH metadata keys.
+var xmpKeys = map[xml.Name]string{
+	{Space: nsDC, Local: "title"}:              huh.KeyTitle,
+	{Space: nsDC, Local: "description"}:        huh.KeyDescription,
+	{Space: nsDC, Local: "creator"}:            huh.KeyAuthor,
+	{Space: nsDC, Local: "rights"}:             huh.KeyCopyright,
+	{Space: nsDC, Local: "subject"}:            huh.KeyKeywords,
+	{Space: nsXMP, Local: "CreatorTool"}:       huh.KeySoftware,
+	{Space: nsXMP, Local: "CreateDate"}:        huh.KeyCreationDate,
+	{Space: nsPhotoshop, Local: "DateCreated"}: huh.KeyCreationDate,
+	{Space: nsEXIF, Local: "DateTimeOriginal"}: huh.KeyCreationDate,
+	{Space: nsTIFF, Local: "Make"}:             huh.KeyCameraMake,
+	{Space: nsTIFF, Local: "Model"}:            huh.KeyCameraModel,
+	{Space: nsTIFF, Local: "Orientation"}:      huh.KeyOrientation,
+	{Space: nsAux, Local: "Lens"}:              huh.KeyLensModel,
+}
+
+// ParseXMP maps the Dublin Core, XMP basic, TIFF and EXIF properties of an
+// XMP packet to HUH metadata. Properties can be given as attributes of
+// rdf:Description or as elements, with lists in rdf:Seq, rdf:Bag or
+// rdf:Alt; of an rdf:Alt only the first, default, item is kept. Properties
+// of the huh namespace, written by Embed, keep their key.
+func ParseXMP(data []byte) (huh.Metadata, error) {
+	meta := huh.Metadata{}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return meta, nil
+		}
+		if err != nil {
+			return meta, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name == (xml.Name{Space: nsRDF, Local: "Description"}) {
+			for _, attr := range start.Attr {
+				if key, ok := xmpKeys[attr.Name]; ok {
+					setXMP(meta, key, attr.Value, nil)
+				} else if attr.Name.Space == nsHUH {
+					setHUH(meta, attr.Name.Local, attr.Value)
+				}
+			}
+			continue
+		}
+		if key, ok := xmpKeys[start.Name]; ok {
+			text, items, err := xmpProperty(d)
+			if err != nil {
+				return meta, err
+			}
+			setXMP(meta, key, text, items)
+		} else if start.Name.Space == nsHUH {
+			text, _, err := xmpProperty(d)
+			if err != nil {
+				return meta, err
+			}
+			setHUH(meta, start.Name.Local, text)
+		}
+	}
+}
+
+// xmpProperty reads the value of the property element just started: its
+// text, or the items of the RDF container inside it.
+func xmpProperty(d *xml.Decoder) (text string, items []string, err error) {
+	var buf strings.Builder
+	var item *strings.Builder
+	for depth := 1; depth > 0; {
+		tok, err := d.Token()
+		if err != nil {
+			return "", nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name == (xml.Name{Space: nsRDF, Local: "li"}) {
+				item = new(strings.Builder)
+			}
+		case xml.EndElement:
+			depth--
+			if item != nil && t.Name == (xml.Name{Space: nsRDF, Local: "li"}) {
+				items = append(items, strings.TrimSpace(item.String()))
+				item = nil
+			}
+		case xml.CharData:
+			if item != nil {
+				item.Write(t)
+			} else if depth == 1 {
+				buf.Write(t)
+			}
+		}
+	}
+	return strings.TrimSpace(buf.String()), items, nil
+}
+
+// setXMP stores the value of a property under key, converted to the type
+// of the key. Values that do not convert are dropped.
+func setXMP(meta huh.Metadata, key, text string, items []string) {
+	if text == "" && len(items) > 0 {
+		text = items[0]
+	}
+	switch key {
+	case huh.KeyKeywords:
+		if len(items) == 0 && text != "" {
+			items = []string{text}
+		}
+		if len(items) > 0 {
+			list := make([]any, len(items))
+			for i, item := range items {
+				list[i] = item
+			}
+			meta[key] = list
+		}
+	case huh.KeyAuthor:
+		if len(items) > 0 {
+			text = strings.Join(items, ", ")
+		}
+		if text != "" {
+			meta[key] = text
+		}
+	case huh.KeyCreationDate:
+		if t, ok := xmpTime(text); ok {
+			meta[key] = t
+		}
+	case huh.KeyOrientation:
+		if v, err := strconv.Atoi(text); err == nil && v >= 1 && v <= 8 {
+			meta[key] = int64(v)
+		}
+	default:
+		if text != "" {
+			meta[key] = text
+		}
+	}
+}
+
+// setHUH stores a property of the huh namespace, in the text form of
+// huh.FormatValue. Values that do not parse are dropped.
+func setHUH(meta huh.Metadata, key, text string) {
+	if v, err := huh.ParseValue(key, "", text); err == nil && text != "" {
+		meta[key] = v
+	}
+}
+
+// xmpTime parses an XMP date, which may leave out the time zone, the
+// seconds or the whole time of day.
+func xmpTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04Z07:00", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,118 @@
+package imgmeta
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"huh/src/huh"
+)
+
+// xmpPacket wraps the properties of an rdf:Description in an XMP packet.
+func xmpPacket(attrs, body string) []byte {
+	return []byte(`<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:tiff="http://ns.adobe.com/tiff/1.0/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:aux="http://ns.adobe.com/exif/1.0/aux/"
+    xmlns:huh="urn:huh:metadata" ` + attrs + `>` + body + `
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`)
+}
+
+func TestParseXMP(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs string
+		body  string
+		want  huh.Metadata
+	}{
+		{"alt keeps the default", "", `
+   <dc:title><rdf:Alt>
+     <rdf:li xml:lang="x-default">Harbour at dawn</rdf:li>
+     <rdf:li xml:lang="de">Hafen im Morgengrauen</rdf:li>
+   </rdf:Alt></dc:title>
+   <dc:rights><rdf:Alt><rdf:li xml:lang="x-default"> CC BY 4.0 </rdf:li></rdf:Alt></dc:rights>`,
+			huh.Metadata{huh.KeyTitle: "Harbour at dawn", huh.KeyCopyright: "CC BY 4.0"}},
+		{"seq joins the creators", "", `
+   <dc:creator><rdf:Seq>
+     <rdf:li>Jane Doe</rdf:li>
+     <rdf:li>John Roe</rdf:li>
+   </rdf:Seq></dc:creator>`,
+			huh.Metadata{huh.KeyAuthor: "Jane Doe, John Roe"}},
+		{"bag lists the keywords", "", `
+   <dc:subject><rdf:Bag>
+     <rdf:li>sea</rdf:li>
+     <rdf:li>boats</rdf:li>
+     <rdf:li>morning</rdf:li>
+   </rdf:Bag></dc:subject>`,
+			huh.Metadata{huh.KeyKeywords: []any{"sea", "boats", "morning"}}},
+		{"single keyword", "", `<dc:subject>sea</dc:subject>`,
+			huh.Metadata{huh.KeyKeywords: []any{"sea"}}},
+		{"attributes", `tiff:Make="Acme" tiff:Model="Snapper 3000" tiff:Orientation="8" xmp:CreatorTool="huh"`, "",
+			huh.Metadata{huh.KeyCameraMake: "Acme", huh.KeyCameraModel: "Snapper 3000", huh.KeyOrientation: int64(8), huh.KeySoftware: "huh"}},
+		{"elements", "", `
+   <aux:Lens>50mm f/1.8</aux:Lens>
+   <tiff:Orientation>3</tiff:Orientation>`,
+			huh.Metadata{huh.KeyLensModel: "50mm f/1.8", huh.KeyOrientation: int64(3)}},
+		{"invalid values are dropped", `tiff:Orientation="9"`, `
+   <xmp:CreateDate>yesterday</xmp:CreateDate>
+   <dc:description><rdf:Alt></rdf:Alt></dc:description>`,
+			huh.Metadata{}},
+		{"huh namespace", `huh:iso="200" huh:comment="kept as is"`, `<huh:f_number>5.6</huh:f_number>`,
+			huh.Metadata{huh.KeyISO: int64(200), huh.KeyComment: "kept as is", huh.KeyFNumber: 5.6}},
+		{"other namespaces are ignored", `xmlns:foo="urn:foo" foo:title="no"`, `<foo:creator>no</foo:creator>`,
+			huh.Metadata{}},
+	}
+	for _, tt := range tests {
+		meta, err := ParseXMP(xmpPacket(tt.attrs, tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(meta, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, meta, tt.want)
+		}
+	}
+}
+
+func TestParseXMPDates(t *testing.T) {
+	tests := []struct {
+		text string
+		want time.Time
+	}{
+		{"2024-05-06T07:08:09+02:00", time.Date(2024, 5, 6, 5, 8, 9, 0, time.UTC)},
+		{"2024-05-06T07:08:09.5Z", time.Date(2024, 5, 6, 7, 8, 9, 5e8, time.UTC)},
+		{"2024-05-06T07:08:09", time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)},
+		{"2024-05-06T07:08-05:00", time.Date(2024, 5, 6, 12, 8, 0, 0, time.UTC)},
+		{"2024-05-06T07:08", time.Date(2024, 5, 6, 7, 8, 0, 0, time.UTC)},
+		{"2024-05-06", time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		meta, err := ParseXMP(xmpPacket("", "<xmp:CreateDate>"+tt.text+"</xmp:CreateDate>"))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.text, err)
+		}
+		if got, _ := meta[huh.KeyCreationDate].(time.Time); !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.text, meta[huh.KeyCreationDate], tt.want)
+		}
+	}
+}
+
+func TestParseXMPMalformed(t *testing.T) {
+	for _, data := range []string{
+		`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description`,
+		string(xmpPacket("", "<dc:title><rdf:Alt><rdf:li>unclosed")),
+		"<a></b>",
+	} {
+		if _, err := ParseXMP([]byte(data)); err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Package imageutil holds the helpers that the image codecs of this module
+// share.
+package imageutil
+
+import (
+	"image"
+	"io"
+)
+
+// Opaque reports whether every pixel of img is fully opaque.
+func Opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// NoEOF turns io.EOF into io.ErrUnexpectedEOF, for decoders that run out
+// of input partway through an image.
+func NoEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
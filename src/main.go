@@ -3,32 +3,42 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"compress/flate"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
+	"image/color/palette"
+	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
+	"io/fs"
 	"log"
+	"math/rand/v2"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
+	"huh/src/farbfeld"
+	"huh/src/huh"
+	"huh/src/imgmeta"
+	"huh/src/pnm"
+	"huh/src/qoi"
 
 	"github.com/eliukblau/pixterm/pkg/ansimage"
 	fcolor "github.com/fatih/color"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 	"golang.org/x/term"
 )
 
@@ -40,34 +50,26 @@ const LOGO = `
  | | | | |_| | | | | | \__/\ (_) | | | \ V /  __/ |  | ||  __/ |
  \_| |_/\___/\_| |_|  \____/\___/|_|_|\_/ \___|_|   \__\___|_|
 `
-const (
-	HUH_MAGIC   = "HUH!"
-	HUH_VERSION = 2
-	UPLOADS_DIR = "uploads"
-)
-
-type Metadata map[string]string
-
-var (
-	imageToHuhFunc func(image.Image, Metadata, string) error
-	huhToImageFunc func(string) (image.Image, Metadata, error)
-)
+const UPLOADS_DIR = "uploads"
 
 func printFancyHeader() {
 	fcolor.Cyan(LOGO)
-	fmt.Println("\n   Universal Image Converter & Viewer v2 (Enhanced) \n")
+	fmt.Print("\n   Universal Image Converter & Viewer v2 (Enhanced) \n\n")
 }
 
+// Status messages and progress bars go to stderr, so they stay out of
+// image data written to stdout.
+
 func printInfo(message string) {
-	fcolor.Blue("INFO: %s\n", message)
+	fmt.Fprintln(os.Stderr, fcolor.BlueString("INFO: %s", message))
 }
 
 func printSuccess(message string) {
-	fcolor.Green("SUCCESS: %s\n", message)
+	fmt.Fprintln(os.Stderr, fcolor.GreenString("SUCCESS: %s", message))
 }
 
 func printError(message string) {
-	fcolor.Red("ERROR: %s\n", message)
+	fmt.Fprintln(os.Stderr, fcolor.RedString("ERROR: %s", message))
 }
 
 func printProgress(progress float32) {
@@ -75,196 +77,906 @@ func printProgress(progress float32) {
 	filled := int(progress * float32(barWidth))
 	empty := barWidth - filled
 	bar := fcolor.GreenString(strings.Repeat("█", filled)) + strings.Repeat(" ", empty)
-	fmt.Printf("\r[%s] %.1f%%", bar, progress*100)
+	fmt.Fprintf(os.Stderr, "\r[%s] %.1f%%", bar, progress*100)
 	if progress >= 1.0 {
-		fmt.Println()
+		fmt.Fprintln(os.Stderr)
 	}
 }
 
-func imageToHuh(img image.Image, metadata Metadata, huhPath string) error {
-	outFile, err := os.Create(huhPath)
+func imageToHuh(img image.Image, huhPath string, opts *huh.Options) error {
+	return writeFileAtomic(huhPath, func(w io.Writer) error {
+		return huh.Encode(w, img, opts)
+	})
+}
+
+func huhToImage(huhPath string, decoder *huh.Decoder) (image.Image, huh.Metadata, error) {
+	file, err := os.Open(huhPath)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	defer file.Close()
+	return decoder.Decode(file)
+}
+
+// autoOrient turns img upright according to the EXIF orientation in meta,
+// moving the orientation to original_orientation there.
+func autoOrient(img image.Image, meta huh.Metadata) image.Image {
+	orientation, _ := meta[huh.KeyOrientation].(int64)
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	meta[huh.KeyOriginalOrientation] = orientation
+	meta[huh.KeyOrientation] = int64(1)
+	return imgmeta.Orient(img, int(orientation))
+}
+
+// huhRegionToImage decodes only the part of a HUH file inside rect.
+func huhRegionToImage(huhPath string, decoder *huh.Decoder, rect image.Rectangle) (image.Image, error) {
+	file, err := os.Open(huhPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := decoder.DecodeRegion(file, rect)
+	return img, err
+}
+
+// huhThumbnail reads the embedded thumbnail of a HUH file, falling back to
+// scaling down the full image for files written without one.
+func huhThumbnail(huhPath string, decoder *huh.Decoder) (image.Image, error) {
+	file, err := os.Open(huhPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, err := decoder.DecodeThumbnail(file)
+	if !errors.Is(err, huh.ErrNoThumbnail) {
+		return img, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
 	}
-	defer outFile.Close()
+	img, _, err = decoder.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	return huh.Thumbnail(img, huh.DefaultThumbnailSize), nil
+}
+
+// formatMetaValue renders a typed metadata value for the terminal.
+func formatMetaValue(v any) string {
+	s, err := huh.FormatValue(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return s
+}
+
+// parseCrop parses a crop rectangle given as "x,y,w,h". An empty string
+// yields an empty rectangle.
+func parseCrop(s string) (image.Rectangle, error) {
+	if s == "" {
+		return image.Rectangle{}, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("crop must be x,y,w,h: %q", s)
+	}
+	var v [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("crop must be x,y,w,h: %q", s)
+		}
+		v[i] = n
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("crop width and height must be positive: %q", s)
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
 
-	if _, err := outFile.WriteString(HUH_MAGIC); err != nil {
+// parseFlags parses args with fs, allowing flags to come before, between
+// or after the positional arguments, which it returns.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// compressionOptions maps the --compression flag to HUH encoder options.
+func compressionOptions(name string) (*huh.Options, error) {
+	switch name {
+	case "best":
+		return &huh.Options{Compression: huh.CompressionDeflate, Level: 9}, nil
+	case "fast":
+		return &huh.Options{Compression: huh.CompressionLZ}, nil
+	case "none":
+		return &huh.Options{Compression: huh.CompressionNone}, nil
+	}
+	if level, err := strconv.Atoi(name); err == nil && level >= 1 && level <= 9 {
+		return &huh.Options{Compression: huh.CompressionDeflate, Level: level}, nil
+	}
+	return nil, fmt.Errorf("unknown compression %q: use fast, best, none or a level from 1 to 9", name)
+}
+
+// colorTypeOption parses the --color-type flag. "auto" leaves the choice
+// to the encoder and yields nil.
+func colorTypeOption(name string) (*huh.ColorType, error) {
+	if name == "auto" {
+		return nil, nil
+	}
+	for _, ct := range []huh.ColorType{huh.ColorRGB, huh.ColorRGBA, huh.ColorGray, huh.ColorPalette} {
+		if ct.String() == name {
+			return &ct, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown color type %q: use auto, rgb, rgba, gray or palette", name)
+}
+
+func verifyFile(huhPath string) error {
+	file, err := os.Open(huhPath)
+	if err != nil {
 		return err
 	}
-	if err := binary.Write(outFile, binary.LittleEndian, uint8(HUH_VERSION)); err != nil {
+	defer file.Close()
+	return huh.Verify(file)
+}
+
+// metaCommand runs "huh meta list|get|set|delete <file> [key] [value]".
+func metaCommand(args []string) error {
+	fs := flag.NewFlagSet("meta", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	valueType := fs.String("type", "", "")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
 		return err
 	}
+	arity := map[string]int{"list": 2, "get": 3, "set": 4, "delete": 3}
+	if len(positional) == 0 || arity[positional[0]] != len(positional) {
+		printUsage()
+		return errors.New("invalid arguments for meta command")
+	}
+	action, huhPath := positional[0], positional[1]
 
-	metadataJSON, err := json.Marshal(metadata)
+	switch action {
+	case "list", "get":
+		file, err := os.Open(huhPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, meta, err := new(huh.Decoder).DecodeConfig(file)
+		if err != nil {
+			return err
+		}
+		if action == "get" {
+			v, ok := meta[positional[2]]
+			if !ok {
+				return fmt.Errorf("%s has no %q metadata", huhPath, positional[2])
+			}
+			fmt.Println(formatMetaValue(v))
+			return nil
+		}
+		keys := make([]string, 0, len(meta))
+		for k := range meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s: %s\n", k, formatMetaValue(meta[k]))
+		}
+		return nil
+
+	case "set":
+		key := positional[2]
+		v, err := huh.ParseValue(key, *valueType, positional[3])
+		if err != nil {
+			return err
+		}
+		if err := editHuhMetadata(huhPath, func(meta huh.Metadata) error {
+			meta[key] = v
+			return nil
+		}); err != nil {
+			return err
+		}
+		printSuccess(fmt.Sprintf("Set %s in %s", key, huhPath))
+
+	case "delete":
+		key := positional[2]
+		if err := editHuhMetadata(huhPath, func(meta huh.Metadata) error {
+			if _, ok := meta[key]; !ok {
+				return fmt.Errorf("%s has no %q metadata", huhPath, key)
+			}
+			delete(meta, key)
+			return nil
+		}); err != nil {
+			return err
+		}
+		printSuccess(fmt.Sprintf("Deleted %s from %s", key, huhPath))
+	}
+	return nil
+}
+
+// editHuhMetadata rewrites the metadata of a HUH file without touching its
+// pixel data. The new file is written next to the old one and renamed over
+// it, so an error or crash never leaves a half-written file behind.
+func editHuhMetadata(huhPath string, edit func(huh.Metadata) error) error {
+	in, err := os.Open(huhPath)
 	if err != nil {
 		return err
 	}
-	if err := binary.Write(outFile, binary.LittleEndian, uint32(len(metadataJSON))); err != nil {
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
 		return err
 	}
-	if _, err := outFile.Write(metadataJSON); err != nil {
+
+	tmp, err := os.CreateTemp(filepath.Dir(huhPath), "."+filepath.Base(huhPath)+".tmp-*")
+	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	bounds := img.Bounds()
-	width, height := uint32(bounds.Max.X), uint32(bounds.Max.Y)
-	if err := binary.Write(outFile, binary.LittleEndian, width); err != nil {
+	bw := bufio.NewWriter(tmp)
+	if err := huh.EditMetadata(bw, in, edit); err != nil {
+		tmp.Close()
 		return err
 	}
-	if err := binary.Write(outFile, binary.LittleEndian, height); err != nil {
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
 		return err
 	}
-
-	compressor, err := flate.NewWriter(outFile, flate.BestCompression)
-	if err != nil {
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
 		return err
 	}
-	defer compressor.Close()
+	return os.Rename(tmp.Name(), huhPath)
+}
 
-	totalPixels := int(width * height)
-	pixelCount := 0
-	for y := 0; y < int(height); y++ {
-		for x := 0; x < int(width); x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			pixelData := []byte{byte(r >> 8), byte(g >> 8), byte(b >> 8)}
-			if _, err := compressor.Write(pixelData); err != nil {
-				return err
-			}
-			pixelCount++
-			if totalPixels > 100 && pixelCount%(totalPixels/100+1) == 0 {
-				printProgress(float32(pixelCount) / float32(totalPixels))
+// convertOptions are the settings of the convert command.
+type convertOptions struct {
+	// huh holds the encoding options of HUH output; Quality also applies
+	// to JPEG output.
+	huh           *huh.Options
+	stripMetadata bool
+	autoOrient    bool
+	// to is the output format, or nil to go by the output extension.
+	to *imageFormat
+}
+
+// imageFormat is an image file format convert reads or writes.
+type imageFormat struct {
+	name string
+	mime string
+	// magic are the signatures files of the format start with, where '?'
+	// matches any byte. Formats without one are only written.
+	magic []string
+	// exts are the file extensions of the format, in lower case.
+	exts []string
+	// decode reads every frame of a file, with its metadata and color
+	// profile, reporting progress to progress if it is not nil and the
+	// format decodes in steps.
+	decode func(data []byte, progress func(float32)) (*huh.Animation, *imgmeta.Info, error)
+	// encode writes anim, or its first frame for formats without
+	// animation, carrying over what the format can hold of info, which is
+	// nil if metadata is stripped. It is nil for formats that can only be
+	// read.
+	encode encodeFunc
+}
+
+type encodeFunc func(w io.Writer, anim *huh.Animation, info *imgmeta.Info, opts *huh.Options) error
+
+// imageFormats are the formats convert knows, HUH first.
+var imageFormats = []*imageFormat{
+	{name: "huh", mime: "image/x-huh", magic: []string{huh.Magic}, exts: []string{".huh"},
+		decode: decodeHuh, encode: encodeHuh},
+	{name: "png", mime: "image/png", magic: []string{"\x89PNG\r\n\x1a\n"}, exts: []string{".png"},
+		decode: decodeStill, encode: withMetadata(encodeStill(png.Encode))},
+	{name: "jpeg", mime: "image/jpeg", magic: []string{"\xff\xd8"}, exts: []string{".jpg", ".jpeg"},
+		decode: decodeStill, encode: withMetadata(encodeJPEG)},
+	{name: "gif", mime: "image/gif", magic: []string{"GIF87a", "GIF89a"}, exts: []string{".gif"},
+		decode: decodeGIF, encode: encodeGIF},
+	{name: "bmp", mime: "image/bmp", magic: []string{"BM"}, exts: []string{".bmp"},
+		decode: decodeStill, encode: encodeStill(bmp.Encode)},
+	{name: "tiff", mime: "image/tiff", magic: []string{"II*\x00", "MM\x00*"}, exts: []string{".tif", ".tiff"},
+		decode: decodeStill, encode: encodeStill(func(w io.Writer, img image.Image) error {
+			return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+		})},
+	{name: "webp", mime: "image/webp", magic: []string{"RIFF????WEBP"}, exts: []string{".webp"},
+		decode: decodeStill},
+	{name: "qoi", mime: "image/qoi", magic: []string{qoi.Magic}, exts: []string{".qoi"},
+		decode: decodeStill, encode: encodeStill(qoi.Encode)},
+	{name: "pbm", mime: "image/x-portable-bitmap", magic: []string{"P1", "P4"}, exts: []string{".pbm"},
+		decode: decodeStill},
+	{name: "pgm", mime: "image/x-portable-graymap", magic: []string{"P2", "P5"}, exts: []string{".pgm"},
+		decode: decodeStill, encode: encodePNM(pnm.PGM)},
+	{name: "ppm", mime: "image/x-portable-pixmap", magic: []string{"P3", "P6"}, exts: []string{".ppm"},
+		decode: decodeStill, encode: encodePNM(pnm.PPM)},
+	{name: "pam", mime: "image/x-portable-arbitrarymap", magic: []string{"P7"}, exts: []string{".pam"},
+		decode: decodeStill, encode: encodePNM(pnm.PAM)},
+	// PNM files are PBM, PGM or PPM files, found by their own magic.
+	{name: "pnm", mime: "image/x-portable-anymap", exts: []string{".pnm"},
+		encode: encodePNM(pnm.Auto)},
+	{name: "farbfeld", mime: "image/x-farbfeld", magic: []string{farbfeld.Magic}, exts: []string{".ff"},
+		decode: decodeStill, encode: encodeStill(farbfeld.Encode)},
+}
+
+// sniffFormat returns the format whose magic data starts with, or nil.
+func sniffFormat(data []byte) *imageFormat {
+	for _, f := range imageFormats {
+		for _, magic := range f.magic {
+			if matchMagic(magic, data) {
+				return f
 			}
 		}
 	}
-	if totalPixels > 100 {
-		printProgress(1.0)
-	}
 	return nil
 }
 
-func huhToImage(huhPath string) (image.Image, Metadata, error) {
-	file, err := os.Open(huhPath)
-	if err != nil {
-		return nil, nil, err
+func matchMagic(magic string, data []byte) bool {
+	if len(data) < len(magic) {
+		return false
 	}
-	defer file.Close()
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != data[i] {
+			return false
+		}
+	}
+	return true
+}
 
-	magic := make([]byte, 4)
-	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != HUH_MAGIC {
-		return nil, nil, errors.New("invalid HUH file: bad magic number")
+// formatByExt returns the format of path by its extension, or nil.
+func formatByExt(path string) *imageFormat {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, f := range imageFormats {
+		for _, e := range f.exts {
+			if e == ext {
+				return f
+			}
+		}
 	}
+	return nil
+}
 
-	var version uint8
-	if err := binary.Read(file, binary.LittleEndian, &version); err != nil || version != HUH_VERSION {
-		return nil, nil, fmt.Errorf("unsupported HUH version: %d", version)
+// lookupFormat returns the format called name, which may also be one of
+// its extensions, with or without the dot, or its MIME type.
+func lookupFormat(name string) (*imageFormat, error) {
+	name = strings.ToLower(name)
+	for _, f := range imageFormats {
+		if name == f.name || name == f.mime {
+			return f, nil
+		}
+		for _, e := range f.exts {
+			if name == e || name == e[1:] {
+				return f, nil
+			}
+		}
 	}
+	return nil, fmt.Errorf("unknown image format: %s", name)
+}
 
-	var metaLen uint32
-	if err := binary.Read(file, binary.LittleEndian, &metaLen); err != nil {
+func decodeHuh(data []byte, progress func(float32)) (*huh.Animation, *imgmeta.Info, error) {
+	decoder := &huh.Decoder{Progress: progress}
+	anim, meta, err := decoder.DecodeAll(bytes.NewReader(data))
+	if err != nil {
 		return nil, nil, err
 	}
+	profile, err := huh.DecodeICCProfile(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	return anim, &imgmeta.Info{Metadata: meta, ICCProfile: profile}, nil
+}
 
-	metaJSON := make([]byte, metaLen)
-	if _, err := io.ReadFull(file, metaJSON); err != nil {
+func decodeGIF(data []byte, _ func(float32)) (*huh.Animation, *imgmeta.Info, error) {
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
 		return nil, nil, err
 	}
-	var metadata Metadata
-	if err := json.Unmarshal(metaJSON, &metadata); err != nil {
+	if err := checkImageSize(cfg); err != nil {
 		return nil, nil, err
 	}
-
-	var width, height uint32
-	if err := binary.Read(file, binary.LittleEndian, &width); err != nil {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
 		return nil, nil, err
 	}
-	if err := binary.Read(file, binary.LittleEndian, &height); err != nil {
+	return gifToAnimation(g), &imgmeta.Info{Metadata: huh.Metadata{}}, nil
+}
+
+// checkImageSize refuses images larger than the limits of serverDecoder,
+// which the command line applies to other formats too.
+func checkImageSize(cfg image.Config) error {
+	limits := serverDecoder.Limits
+	if cfg.Width > limits.MaxWidth || cfg.Height > limits.MaxHeight || bytesPerPixel(cfg.ColorModel)*int64(cfg.Width)*int64(cfg.Height) > limits.MaxDecodedBytes {
+		return fmt.Errorf("%dx%d image exceeds the size limits", cfg.Width, cfg.Height)
+	}
+	return nil
+}
+
+// decodeImage decodes data with the decoders registered with the image
+// package. The size in the header is checked first: a BMP, TIFF or QOI
+// header of a few bytes can claim gigabytes of pixels, which the decoder
+// would allocate before finding out the data is missing.
+func decodeImage(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkImageSize(cfg); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// decodeStill decodes a single image with decodeImage, and reads the EXIF,
+// XMP and text metadata and the ICC profile embedded in it. Metadata that
+// cannot be read is reported and left out rather than failing the
+// conversion.
+func decodeStill(data []byte, _ func(float32)) (*huh.Animation, *imgmeta.Info, error) {
+	img, err := decodeImage(data)
+	if err != nil {
 		return nil, nil, err
 	}
+	info, err := imgmeta.Read(bytes.NewReader(data))
+	if err != nil {
+		printInfo(fmt.Sprintf("Some embedded metadata could not be read: %v", err))
+	}
+	return &huh.Animation{Image: []image.Image{img}}, info, nil
+}
 
-	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
-	decompressor := flate.NewReader(file)
-	defer decompressor.Close()
+func encodeHuh(w io.Writer, anim *huh.Animation, info *imgmeta.Info, opts *huh.Options) error {
+	o := *opts
+	if info != nil {
+		o.Metadata, o.ICCProfile = info.Metadata, info.ICCProfile
+	}
+	if len(anim.Image) > 1 {
+		return huh.EncodeAll(w, anim, &o)
+	}
+	return huh.Encode(w, anim.Image[0], &o)
+}
 
-	totalPixels := int(width * height)
-	pixelBuffer := make([]byte, totalPixels*3)
-	if _, err := io.ReadFull(decompressor, pixelBuffer); err != nil {
-		return nil, nil, fmt.Errorf("failed to decompress pixel data: %w", err)
+func encodeGIF(w io.Writer, anim *huh.Animation, _ *imgmeta.Info, _ *huh.Options) error {
+	if len(anim.Image) > 1 {
+		return gif.EncodeAll(w, animationToGIF(anim))
 	}
+	return gif.Encode(w, anim.Image[0], &gif.Options{NumColors: 256})
+}
 
-	for i := 0; i < totalPixels; i++ {
-		x, y := i%int(width), i/int(width)
-		offset := i * 3
-		img.Set(x, y, color.RGBA{R: pixelBuffer[offset], G: pixelBuffer[offset+1], B: pixelBuffer[offset+2], A: 255})
-		if totalPixels > 100 && i%(totalPixels/100+1) == 0 {
-			printProgress(float32(i) / float32(totalPixels))
-		}
+func encodeJPEG(w io.Writer, anim *huh.Animation, _ *imgmeta.Info, opts *huh.Options) error {
+	quality := 90
+	if opts.Quality > 0 {
+		quality = opts.Quality
 	}
-	if totalPixels > 100 {
-		printProgress(1.0)
+	return jpeg.Encode(w, anim.Image[0], &jpeg.Options{Quality: quality})
+}
+
+func encodePNM(format pnm.Format) encodeFunc {
+	return encodeStill(func(w io.Writer, img image.Image) error {
+		return pnm.Encode(w, img, &pnm.Options{Format: format})
+	})
+}
+
+// encodeStill adapts the encoder of a format without animation or
+// metadata, which gets the first frame.
+func encodeStill(encode func(io.Writer, image.Image) error) encodeFunc {
+	return func(w io.Writer, anim *huh.Animation, _ *imgmeta.Info, _ *huh.Options) error {
+		return encode(w, anim.Image[0])
 	}
+}
 
-	return img, metadata, nil
+// withMetadata makes encode embed the metadata and color profile with
+// imgmeta.Embed, for PNG and JPEG, whose encoders leave them out.
+func withMetadata(encode encodeFunc) encodeFunc {
+	return func(w io.Writer, anim *huh.Animation, info *imgmeta.Info, opts *huh.Options) error {
+		if info == nil {
+			return encode(w, anim, nil, opts)
+		}
+		var buf bytes.Buffer
+		if err := encode(&buf, anim, info, opts); err != nil {
+			return err
+		}
+		return imgmeta.Embed(w, buf.Bytes(), info)
+	}
 }
 
-func convertImage(inputPath, outputPath string) error {
-	inputFile, err := os.Open(inputPath)
+// convertFile converts inputPath to outputPath, either of which may be "-"
+// for stdin or stdout. The input format is found from the content, and the
+// output format is opts.to or else follows from the extension. Metadata is
+// carried over as far as the output format can hold it, and images
+// imported from other formats are turned upright. Converting HUH to HUH
+// re-encodes the file with the options given.
+func convertFile(inputPath, outputPath string, opts *convertOptions) error {
+	out := opts.to
+	if outputPath == "-" {
+		if out == nil {
+			return errors.New("writing to stdout needs the output format in --to")
+		}
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			return errors.New("refusing to write image data to a terminal")
+		}
+	}
+	if out == nil {
+		if out = formatByExt(outputPath); out == nil {
+			return fmt.Errorf("cannot tell the output format from %q, use --to", filepath.Base(outputPath))
+		}
+	}
+	if out.encode == nil {
+		return fmt.Errorf("%s images can be read but not written", strings.ToUpper(out.name))
+	}
+
+	data, err := readInput(inputPath)
+	if err != nil {
+		return err
+	}
+	in := sniffFormat(data)
+	if in == nil {
+		return errors.New("not in a supported image format")
+	}
+	if ext := formatByExt(inputPath); ext != nil && ext != in && ext.magic != nil {
+		printInfo(fmt.Sprintf("%s holds %s data despite its extension", inputPath, strings.ToUpper(in.name)))
+	}
+	anim, info, err := in.decode(data, opts.huh.Progress)
 	if err != nil {
 		return err
 	}
-	defer inputFile.Close()
+	if in.name != "huh" {
+		if opts.autoOrient && len(anim.Image) == 1 {
+			anim.Image[0] = autoOrient(anim.Image[0], info.Metadata)
+		}
+		if out.name == "huh" && inputPath != "-" {
+			info.Metadata[huh.KeySourceFile] = filepath.Base(inputPath)
+		}
+	}
+	if opts.stripMetadata {
+		info = nil
+	}
+	write := func(w io.Writer) error {
+		return out.encode(w, anim, info, opts.huh)
+	}
+	if outputPath == "-" {
+		bw := bufio.NewWriter(os.Stdout)
+		if err := write(bw); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	return writeFileAtomic(outputPath, write)
+}
+
+// readInput reads the file at path, or stdin if path is "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// displayName names path in messages.
+func displayName(path string) string {
+	if path == "-" {
+		return "standard input"
+	}
+	return path
+}
 
-	img, _, err := image.Decode(inputFile)
+// writeFileAtomic writes path with write, through a temporary file next to
+// it that is renamed over it, so an error never leaves a half-written file
+// behind, and converting a file onto itself is safe. A file that is
+// replaced keeps its permissions, and a new one gets those of os.Create.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := createTemp(path)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	outputFile, err := os.Create(outputPath)
+	bw := bufio.NewWriter(tmp)
+	if err := write(bw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// createTemp creates a new temporary file next to path. Unlike
+// os.CreateTemp, which uses mode 0600, it creates the file with mode 0666
+// less the umask, like os.Create.
+func createTemp(path string) (*os.File, error) {
+	prefix := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	for try := 0; ; try++ {
+		f, err := os.OpenFile(prefix+strconv.FormatUint(rand.Uint64(), 36), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) || try == 100 {
+			return f, err
+		}
+	}
+}
+
+// batchJob is one file of a batch conversion, with its outcome.
+type batchJob struct {
+	input, output string
+	skipped       bool
+	err           error
+	elapsed       time.Duration
+}
+
+// convertBatch converts every image the patterns name into outDir, in the
+// format opts.to, on the given number of workers. A pattern is a file, a
+// directory, whose images are all converted, or a glob, in which "**"
+// matches any number of directories. Outputs keep the paths of their
+// inputs relative to the directory or the part of the glob before the
+// first wildcard, and are skipped if they are at least as new as their
+// input unless force is set. Failed files are reported with the rest
+// rather than stopping the batch.
+func convertBatch(patterns []string, outDir string, workers int, force bool, opts *convertOptions) error {
+	jobs, err := planBatch(patterns, outDir, opts.to)
 	if err != nil {
 		return err
 	}
-	defer outputFile.Close()
+	if len(jobs) == 0 {
+		return errors.New("no images to convert")
+	}
+	plural := "s"
+	if workers == 1 {
+		plural = ""
+	}
+	printInfo(fmt.Sprintf("Converting %d files to %s on %d worker%s", len(jobs), strings.ToUpper(opts.to.name), workers, plural))
+
+	// Progress bars of concurrent conversions would run into each other.
+	o := *opts
+	hopts := *opts.huh
+	hopts.Progress = nil
+	o.huh = &hopts
+
+	queue := make(chan *batchJob)
+	done := make(chan *batchJob)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range queue {
+				runBatchJob(job, force, &o)
+				done <- job
+			}
+		}()
+	}
+	go func() {
+		for _, job := range jobs {
+			if job.err == nil {
+				queue <- job
+			} else {
+				done <- job
+			}
+		}
+		close(queue)
+	}()
+	for i := range jobs {
+		<-done
+		printProgress(float32(i+1) / float32(len(jobs)))
+	}
 
-	ext := strings.ToLower(filepath.Ext(outputPath))
-	switch ext {
-	case ".png":
-		err = png.Encode(outputFile, img)
-	case ".jpg", ".jpeg":
-		err = jpeg.Encode(outputFile, img, &jpeg.Options{Quality: 90})
-	case ".gif":
-		err = gif.Encode(outputFile, img, &gif.Options{NumColors: 256})
-	default:
-		return fmt.Errorf("unsupported output format: %s", ext)
+	converted, skipped, failed := 0, 0, 0
+	for _, job := range jobs {
+		switch {
+		case job.err != nil:
+			failed++
+			printError(fmt.Sprintf("%s: %v", job.input, job.err))
+		case job.skipped:
+			skipped++
+			printInfo(fmt.Sprintf("%s: %s is up to date", job.input, job.output))
+		default:
+			converted++
+			printSuccess(fmt.Sprintf("%s -> %s (%s)", job.input, job.output, job.elapsed.Round(time.Millisecond)))
+		}
+	}
+	printInfo(fmt.Sprintf("%d converted, %d up to date, %d failed", converted, skipped, failed))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to convert", failed, len(jobs))
 	}
-	return err
+	return nil
 }
 
-func viewImage(path string) error {
-	var img image.Image
-	var err error
-	var meta Metadata
+// runBatchJob converts the file of job unless its output is up to date.
+func runBatchJob(job *batchJob, force bool, opts *convertOptions) {
+	in, err := os.Stat(job.input)
+	if err != nil {
+		job.err = err
+		return
+	}
+	if out, err := os.Stat(job.output); err == nil && !force && !out.ModTime().Before(in.ModTime()) {
+		job.skipped = true
+		return
+	}
+	start := time.Now()
+	if err := os.MkdirAll(filepath.Dir(job.output), 0755); err != nil {
+		job.err = err
+		return
+	}
+	job.err = convertFile(job.input, job.output, opts)
+	job.elapsed = time.Since(start)
+}
 
-	ext := strings.ToLower(filepath.Ext(path))
-	if ext == ".huh" {
-		printInfo("Decoding HUH file...")
-		img, meta, err = huhToImageFunc(path)
-		if err != nil {
-			return err
+// planBatch expands the patterns of a batch into jobs, in order and
+// without duplicates. Inputs that would overwrite the output of an earlier
+// one fail, as do files that do not exist.
+func planBatch(patterns []string, outDir string, to *imageFormat) ([]*batchJob, error) {
+	absOut, err := filepath.Abs(outDir)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*batchJob
+	seen := map[string]bool{}
+	outputs := map[string]string{}
+	add := func(input, rel string, err error) {
+		if seen[input] {
+			return
 		}
-		printInfo("Displaying HUHv2 Image. Metadata:")
-		for k, v := range meta {
-			fmt.Printf("  - %s: %s\n", k, v)
+		seen[input] = true
+		output := filepath.Join(outDir, strings.TrimSuffix(rel, filepath.Ext(rel))+to.exts[0])
+		job := &batchJob{input: input, output: output, err: err}
+		if first, ok := outputs[output]; ok && err == nil {
+			job.err = fmt.Errorf("same output %s as %s", output, first)
+		} else {
+			outputs[output] = input
 		}
-	} else {
-		file, err := os.Open(path)
-		if err != nil {
-			return err
+		jobs = append(jobs, job)
+	}
+
+	for _, pattern := range patterns {
+		base, glob := splitGlob(pattern)
+		if glob == "" {
+			fi, err := os.Stat(pattern)
+			if err != nil || !fi.IsDir() {
+				add(pattern, filepath.Base(pattern), err)
+				continue
+			}
 		}
-		defer file.Close()
-		img, _, err = image.Decode(file)
-		if err != nil {
-			return err
+		matched := false
+		err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path == base {
+					return err
+				}
+				// Report what cannot be read, and go on with the rest.
+				rel, _ := filepath.Rel(base, path)
+				add(path, rel, err)
+				return nil
+			}
+			if d.IsDir() {
+				// Never convert the outputs of an earlier run again.
+				if abs, _ := filepath.Abs(path); abs == absOut && path != base {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			if glob == "" {
+				// Whole directories take the files with a readable image
+				// extension.
+				if f := formatByExt(path); f == nil || f.decode == nil {
+					return nil
+				}
+			} else if !matchGlob(glob, filepath.ToSlash(rel)) {
+				return nil
+			}
+			matched = true
+			add(path, rel, nil)
+			return nil
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if !matched {
+			printInfo(fmt.Sprintf("No images found for %s", pattern))
+		}
+	}
+	return jobs, nil
+}
+
+// splitGlob splits pattern into the directory before its first wildcard
+// and the rest, in slash form, which is empty for patterns without
+// wildcards.
+func splitGlob(pattern string) (base, glob string) {
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	for i, part := range parts {
+		if strings.ContainsAny(part, "*?[") {
+			base = filepath.FromSlash(strings.Join(parts[:i], "/"))
+			if base == "" {
+				base = "."
+			} else if i == 1 && parts[0] == "" {
+				base = string(filepath.Separator)
+			}
+			return base, strings.Join(parts[i:], "/")
 		}
 	}
+	return pattern, ""
+}
+
+// matchGlob reports whether the slash-separated path name matches
+// pattern, in which "**" matches any number of path elements and other
+// elements match as in filepath.Match.
+func matchGlob(pattern, name string) bool {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func viewImage(path string) error {
+	data, err := readInput(path)
+	if err != nil {
+		return err
+	}
+	f := sniffFormat(data)
+	if f == nil {
+		return fmt.Errorf("%s is not in a supported image format", displayName(path))
+	}
+	if f.name == "huh" {
+		printInfo("Decoding HUH file...")
+	}
+	anim, info, err := f.decode(data, printProgress)
+	if err != nil {
+		return err
+	}
+	img := anim.Image[0]
+	if f.name == "huh" {
+		printInfo("Displaying HUH Image. Metadata:")
+		for k, v := range info.Metadata {
+			fmt.Printf("  - %s: %s\n", k, formatMetaValue(v))
+		}
+	}
+	if len(anim.Image) > 1 {
+		return playAnimation(anim)
+	}
 
 	w, h, _ := term.GetSize(int(os.Stdout.Fd()))
 	buf := new(bytes.Buffer)
@@ -280,13 +992,20 @@ func viewImage(path string) error {
 	ansImg.Draw()
 
 	fmt.Println("\nPress 'q' to exit viewer...")
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	keyboard, err := openKeyboard()
+	if err != nil {
+		return err
+	}
+	if keyboard != os.Stdin {
+		defer keyboard.Close()
+	}
+	oldState, err := term.MakeRaw(int(keyboard.Fd()))
 	if err != nil {
 		return err
 	}
-	defer term.Restore(int(os.Stdin.Fd()), oldState)
+	defer term.Restore(int(keyboard.Fd()), oldState)
 
-	reader := bufio.NewReader(os.Stdin)
+	reader := bufio.NewReader(keyboard)
 	for {
 		char, _, err := reader.ReadRune()
 		if err != nil {
@@ -299,6 +1018,185 @@ func viewImage(path string) error {
 	return nil
 }
 
+// openKeyboard returns the terminal the viewer reads keys from: stdin, or
+// the controlling terminal if the image came in through stdin.
+func openKeyboard() (*os.File, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return os.Stdin, nil
+	}
+	return os.Open("/dev/tty")
+}
+
+func gifToAnimation(g *gif.GIF) *huh.Animation {
+	anim := &huh.Animation{
+		Image:     make([]image.Image, len(g.Image)),
+		Delay:     g.Delay,
+		Disposal:  g.Disposal,
+		LoopCount: g.LoopCount,
+		Config:    g.Config,
+	}
+	for i, frame := range g.Image {
+		anim.Image[i] = frame
+	}
+	return anim
+}
+
+// animationToGIF converts the frames of anim to palette images, keeping
+// palette frames as they are.
+func animationToGIF(anim *huh.Animation) *gif.GIF {
+	canvas := anim.Image[0].Bounds()
+	g := &gif.GIF{
+		Image:     make([]*image.Paletted, len(anim.Image)),
+		Delay:     anim.Delay,
+		Disposal:  anim.Disposal,
+		LoopCount: anim.LoopCount,
+		Config:    image.Config{Width: canvas.Max.X, Height: canvas.Max.Y},
+	}
+	for i, frame := range anim.Image {
+		p, ok := frame.(*image.Paletted)
+		if !ok {
+			p = toPaletted(frame)
+		}
+		g.Image[i] = p
+	}
+	return g
+}
+
+// toPaletted converts img to a palette image, exactly if it has no more
+// than 256 colors and with Floyd-Steinberg dithering otherwise.
+func toPaletted(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	var pal color.Palette
+	seen := make(map[color.NRGBA]bool)
+collect:
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if seen[c] {
+				continue
+			}
+			if len(pal) == 256 {
+				pal = nil
+				break collect
+			}
+			seen[c] = true
+			pal = append(pal, c)
+		}
+	}
+
+	if pal == nil {
+		pal = palette.Plan9
+		if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+			// Keep transparent pixels, such as the padding of a first
+			// frame smaller than the canvas.
+			pal = append(color.Palette{color.Transparent}, palette.WebSafe...)
+		}
+		p := image.NewPaletted(bounds, pal)
+		draw.FloydSteinberg.Draw(p, bounds, img, bounds.Min)
+		return p
+	}
+	p := image.NewPaletted(bounds, pal)
+	draw.Draw(p, bounds, img, bounds.Min, draw.Src)
+	return p
+}
+
+// composeFrames draws the frames of anim over each other as a player
+// would, honoring their disposal, and returns what each one shows.
+func composeFrames(anim *huh.Animation) []image.Image {
+	var canvas image.Rectangle
+	for _, frame := range anim.Image {
+		canvas = canvas.Union(frame.Bounds())
+	}
+	if c := image.Rect(0, 0, anim.Config.Width, anim.Config.Height); canvas.In(c) {
+		canvas = c
+	}
+
+	screens := make([]image.Image, len(anim.Image))
+	current := image.NewNRGBA(canvas)
+	for i, frame := range anim.Image {
+		var disposal byte
+		if anim.Disposal != nil {
+			disposal = anim.Disposal[i]
+		}
+		previous := current
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(canvas)
+			copy(previous.Pix, current.Pix)
+		}
+		draw.Draw(current, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		screen := image.NewNRGBA(canvas)
+		copy(screen.Pix, current.Pix)
+		screens[i] = screen
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(current, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			current = previous
+		}
+	}
+	return screens
+}
+
+// playAnimation plays anim in the terminal until it has looped as often
+// as its LoopCount asks, then keeps the last frame until 'q' is pressed.
+func playAnimation(anim *huh.Animation) error {
+	w, h, _ := term.GetSize(int(os.Stdout.Fd()))
+	screens := composeFrames(anim)
+	frames := make([]string, len(screens))
+	for i, screen := range screens {
+		ansImg, err := ansimage.NewScaledFromImage(screen, w, h, color.Transparent, ansimage.ScaleModeFit, 0)
+		if err != nil {
+			return err
+		}
+		// The terminal is in raw mode while playing.
+		frames[i] = strings.ReplaceAll(ansImg.Render(), "\n", "\r\n")
+	}
+
+	keyboard, err := openKeyboard()
+	if err != nil {
+		return err
+	}
+	if keyboard != os.Stdin {
+		defer keyboard.Close()
+	}
+	oldState, err := term.MakeRaw(int(keyboard.Fd()))
+	if err != nil {
+		return err
+	}
+	defer term.Restore(int(keyboard.Fd()), oldState)
+
+	quit := make(chan struct{})
+	go func() {
+		reader := bufio.NewReader(keyboard)
+		for {
+			char, _, err := reader.ReadRune()
+			if err != nil || char == 'q' || char == 'Q' || char == 3 {
+				close(quit)
+				return
+			}
+		}
+	}()
+
+	fmt.Print("\033[2J")
+	for loop := 0; anim.LoopCount == 0 || loop <= max(anim.LoopCount, 0); loop++ {
+		for i, frame := range frames {
+			fmt.Print("\033[H" + frame + "\r\nPress 'q' to exit viewer...")
+			delay := 10
+			if anim.Delay != nil && anim.Delay[i] >= 2 {
+				delay = anim.Delay[i]
+			}
+			select {
+			case <-quit:
+				return nil
+			case <-time.After(time.Duration(delay) * 10 * time.Millisecond):
+			}
+		}
+	}
+	<-quit
+	return nil
+}
+
 const indexHTML = `
 <!DOCTYPE html>
 <html lang="en">
@@ -335,7 +1233,7 @@ const indexHTML = `
                 <div class="mb-4 border-b pb-4">
                     <h3 class="text-lg font-medium mb-2">HUH Dosyası Yükle</h3>
                     <form id="uploadForm" class="flex items-center gap-3">
-                        <input type="file" id="fileInput" name="huhfile" accept=".huh" required class="block w-full text-sm text-slate-500 file:mr-4 file:py-2 file:px-4 file:rounded-full file:border-0 file:text-sm file:font-semibold file:bg-blue-50 file:text-blue-700 hover:file:bg-blue-100"/>
+                        <input type="file" id="fileInput" name="huhfile" accept=".huh,.png,.jpg,.jpeg,.gif,.bmp,.tif,.tiff,.webp,.qoi,.pbm,.pgm,.ppm,.pam,.pnm,.ff" required class="block w-full text-sm text-slate-500 file:mr-4 file:py-2 file:px-4 file:rounded-full file:border-0 file:text-sm file:font-semibold file:bg-blue-50 file:text-blue-700 hover:file:bg-blue-100"/>
                         <button type="submit" id="uploadButton" class="bg-green-600 text-white font-bold py-2 px-4 rounded-lg hover:bg-green-700 transition-colors duration-200 disabled:bg-slate-400">Yükle</button>
                     </form>
                     <div id="uploadStatus" class="mt-2 text-center font-medium h-5 text-sm"></div>
@@ -412,7 +1310,7 @@ const indexHTML = `
             event.preventDefault();
             
             if (fileInput.files.length === 0) {
-                uploadStatus.innerHTML = '<span class="text-red-600">Lütfen bir .huh dosyası seçin.</span>';
+                uploadStatus.innerHTML = '<span class="text-red-600">Lütfen bir .huh veya görsel dosyası seçin.</span>';
                 return;
             }
 
@@ -454,7 +1352,7 @@ const indexHTML = `
             container.className = 'relative group';
 
             const img = document.createElement('img');
-            img.src = '/view/' + filename;
+            img.src = '/thumb/' + filename;
             img.alt = filename;
             img.className = 'w-full h-auto object-cover rounded-md shadow-sm aspect-square';
 
@@ -496,9 +1394,72 @@ const indexHTML = `
 </html>
 `
 
+// serverDecoder decodes files that anyone can upload, so it uses much
+// tighter limits than the CLI.
+var serverDecoder = &huh.Decoder{
+	Limits: &huh.Limits{
+		MaxWidth:        8192,
+		MaxHeight:       8192,
+		MaxMetadataSize: 64 << 10,
+		MaxDecodedBytes: 256 << 20,
+	},
+}
+
 type UploadRequest struct {
 	Image  string `json:"image"`
 	Author string `json:"author"`
+	// Quality, if set, stores the capture lossily; see huh.Options.
+	Quality int `json:"quality,omitempty"`
+}
+
+// decodeUploadedImage decodes an image sent to the server with
+// decodeImage. The image is turned upright, as phone cameras store photos
+// sideways and leave that to the EXIF orientation, which the returned
+// metadata records.
+func decodeUploadedImage(data []byte) (image.Image, huh.Metadata, error) {
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata := huh.Metadata{}
+	if info, _ := imgmeta.Read(bytes.NewReader(data)); info.Metadata[huh.KeyOrientation] != nil {
+		metadata[huh.KeyOrientation] = info.Metadata[huh.KeyOrientation]
+		img = autoOrient(img, metadata)
+	}
+	return img, metadata, nil
+}
+
+// bytesPerPixel is the size of a pixel of the image the standard decoders
+// return for color model m. Unknown models count as 16-bit RGBA.
+func bytesPerPixel(m color.Model) int64 {
+	switch m {
+	case color.GrayModel, color.AlphaModel:
+		return 1
+	case color.Gray16Model, color.Alpha16Model:
+		return 2
+	case color.YCbCrModel:
+		// Without subsampling, as in 4:4:4 JPEGs.
+		return 3
+	case color.RGBAModel, color.NRGBAModel, color.CMYKModel:
+		return 4
+	}
+	if _, ok := m.(color.Palette); ok {
+		return 1
+	}
+	return 8
+}
+
+// uniquePath returns path, or, if a file already exists there, the first
+// of path-1, path-2 and so on, numbered before the extension, that is free.
+func uniquePath(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
 }
 
 func ensureUploadsDir() {
@@ -518,10 +1479,20 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if req.Quality < 0 || req.Quality > 100 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Quality must be between 0 (lossless) and 100"})
+		return
+	}
 
 	b64data := req.Image[strings.IndexByte(req.Image, ',')+1:]
-	imgReader := base64.NewDecoder(base64.StdEncoding, strings.NewReader(b64data))
-	img, _, err := image.Decode(imgReader)
+	data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, strings.NewReader(b64data)))
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid image data"})
+		return
+	}
+	img, metadata, err := decodeUploadedImage(data)
 	if err != nil {
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid image data"})
 		return
@@ -530,13 +1501,13 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	filename := fmt.Sprintf("capture-%d.huh", time.Now().UnixNano())
 	outputPath := filepath.Join(UPLOADS_DIR, filename)
 
-	metadata := Metadata{
-		"author":        req.Author,
-		"creation_date": time.Now().Format(time.RFC3339),
-		"source":        "WebApp Camera API",
-	}
+	metadata["author"] = req.Author
+	metadata["creation_date"] = time.Now()
+	metadata["source"] = "WebApp Camera API"
 
-	err = imageToHuhFunc(img, metadata, outputPath)
+	// DEFLATE level 6 keeps large camera captures quick to save, and the
+	// thumbnail keeps the gallery from decoding them in full.
+	err = imageToHuh(img, outputPath, &huh.Options{Metadata: metadata, Level: 6, Quality: req.Quality, Thumbnail: huh.DefaultThumbnailSize})
 	w.Header().Set("Content-Type", "application/json")
 	if err != nil {
 		log.Printf("Error saving HUH file: %v", err)
@@ -567,14 +1538,37 @@ func handleFileUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	// Security: Sanitize filename and check extension
+	// Security: Sanitize filename and check what the file holds, whatever
+	// its extension claims
 	sanitizedFilename := filepath.Base(handler.Filename)
-	if strings.ToLower(filepath.Ext(sanitizedFilename)) != ".huh" {
-		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only .huh files are allowed"})
+	head := make([]byte, 16)
+	n, _ := io.ReadFull(file, head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to copy file data"})
+		return
+	}
+	format := sniffFormat(head[:n])
+	if format == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only HUH and image files are allowed"})
+		return
+	}
+	if format.name != "huh" {
+		saveUploadedImage(w, file, sanitizedFilename)
+		return
+	}
+
+	// Reject damaged or oversized files before they reach the gallery.
+	if err := serverDecoder.Verify(file); err != nil {
+		log.Printf("Rejected uploaded file %s: %v", sanitizedFilename, err)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid HUH file"})
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to copy file data"})
 		return
 	}
 
-	dstPath := filepath.Join(UPLOADS_DIR, sanitizedFilename)
+	dstPath := filepath.Join(UPLOADS_DIR, strings.TrimSuffix(sanitizedFilename, filepath.Ext(sanitizedFilename))+".huh")
 	dst, err := os.Create(dstPath)
 	if err != nil {
 		log.Printf("Error creating destination file: %v", err)
@@ -593,6 +1587,35 @@ func handleFileUpload(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
 }
 
+// saveUploadedImage converts an image uploaded through /api/upload-file
+// to a HUH file named after it.
+func saveUploadedImage(w http.ResponseWriter, file io.Reader, filename string) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to copy file data"})
+		return
+	}
+	img, metadata, err := decodeUploadedImage(data)
+	if err != nil {
+		log.Printf("Rejected uploaded file %s: %v", filename, err)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid image file"})
+		return
+	}
+	metadata[huh.KeySourceFile] = filename
+	metadata["creation_date"] = time.Now()
+
+	// photo.png and photo.jpg both become photo.huh, so neither may
+	// replace a file that is already in the gallery.
+	dstPath := uniquePath(filepath.Join(UPLOADS_DIR, strings.TrimSuffix(filename, filepath.Ext(filename))+".huh"))
+	if err := imageToHuh(img, dstPath, &huh.Options{Metadata: metadata, Level: 6, Thumbnail: huh.DefaultThumbnailSize}); err != nil {
+		log.Printf("Error saving HUH file: %v", err)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save HUH file"})
+		return
+	}
+	log.Printf("Successfully converted and saved %s", dstPath)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "filename": filepath.Base(dstPath)})
+}
+
 func handleViewImage(w http.ResponseWriter, r *http.Request) {
 	filename := strings.TrimPrefix(r.URL.Path, "/view/")
 	if filename == "" {
@@ -600,11 +1623,30 @@ func handleViewImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	crop, err := parseCrop(r.URL.Query().Get("crop"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	filePath := filepath.Join(UPLOADS_DIR, filename)
-	img, _, err := huhToImageFunc(filePath)
+	var img image.Image
+	if crop.Empty() {
+		img, _, err = huhToImage(filePath, serverDecoder)
+	} else {
+		img, err = huhRegionToImage(filePath, serverDecoder, crop)
+	}
 	if err != nil {
+		if errors.Is(err, huh.ErrOutOfBounds) {
+			http.Error(w, "Crop is outside the image", http.StatusBadRequest)
+			return
+		}
 		log.Printf("Failed to decode HUH file %s: %v", filename, err)
-		http.Error(w, "Could not process image file", http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if errors.Is(err, huh.ErrTooLarge) || errors.Is(err, huh.ErrCorrupt) {
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(w, "Could not process image file", status)
 		return
 	}
 
@@ -615,6 +1657,33 @@ func handleViewImage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleThumbnail serves the embedded thumbnail of a HUH file as PNG, for
+// the gallery grid. Files without one are decoded in full and scaled down.
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	filename := strings.TrimPrefix(r.URL.Path, "/thumb/")
+	if filename == "" {
+		http.Error(w, "Filename not provided", http.StatusBadRequest)
+		return
+	}
+
+	img, err := huhThumbnail(filepath.Join(UPLOADS_DIR, filename), serverDecoder)
+	if err != nil {
+		log.Printf("Failed to decode HUH thumbnail %s: %v", filename, err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, huh.ErrTooLarge) || errors.Is(err, huh.ErrCorrupt) {
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(w, "Could not process image file", status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	if err := png.Encode(w, img); err != nil {
+		log.Printf("Failed to encode thumbnail to PNG for %s: %v", filename, err)
+		http.Error(w, "Could not serve image", http.StatusInternalServerError)
+	}
+}
+
 func handleListImages(w http.ResponseWriter, r *http.Request) {
 	files, err := os.ReadDir(UPLOADS_DIR)
 	if err != nil {
@@ -650,6 +1719,7 @@ func startServer() {
 	http.HandleFunc("/api/upload", handleUpload)
 	http.HandleFunc("/api/upload-file", handleFileUpload) // YENİ EKLENDİ
 	http.HandleFunc("/view/", handleViewImage)
+	http.HandleFunc("/thumb/", handleThumbnail)
 	http.HandleFunc("/api/images", handleListImages)
 
 	port := "8080"
@@ -662,20 +1732,45 @@ func printUsage() {
 	printFancyHeader()
 	fmt.Println("Usage:")
 	fmt.Println("  huh convert <input_file> <output_file>  - Convert between image formats and HUH")
-	fmt.Println("  huh view <file>                        - View an image or HUH file in the terminal")
+	fmt.Println("      --compression=fast|best|none|1-9    - HUH codec: fast LZ, best DEFLATE, none, or a DEFLATE level")
+	fmt.Println("      --color-type=auto|rgb|rgba|gray|palette - HUH color type, detected from the input by default")
+	fmt.Println("      --quality=1-100                     - Store HUH output lossily, or set the JPEG quality")
+	fmt.Println("      --thumbnail=<size>                  - Embed a preview of at most size×size pixels in HUH output")
+	fmt.Println("      --strip-metadata                    - Leave out metadata and color profiles instead of carrying them over")
+	fmt.Println("      --no-auto-orient                    - Keep the pixels as stored instead of applying the EXIF orientation")
+	fmt.Println("      --to=<format>                       - Output format, by name, extension or MIME type, instead of the output extension")
+	fmt.Println("      Formats: huh, png, jpeg, gif, bmp, tiff, qoi, pgm, ppm, pam, pnm, farbfeld; webp and pbm are read only")
+	fmt.Println("      Input formats are detected from the file content")
+	fmt.Println("      Use - as input or output for stdin or stdout; writing to stdout needs --to")
+	fmt.Println("  huh convert --to=<format> --out-dir=<dir> <file|dir|glob...> - Convert many images, recursing into directories")
+	fmt.Println("      --jobs=<n>                          - Number of files converted at once, one per CPU by default")
+	fmt.Println("      --force                             - Convert files whose output is already up to date too")
+	fmt.Println("  huh view <file>                        - View an image or HUH file in the terminal, - for stdin")
+	fmt.Println("  huh verify <file...>                   - Check HUH files for truncation and checksum errors")
+	fmt.Println("  huh meta list|get|set|delete <file> [key] [value] - Edit HUH metadata without re-encoding pixels")
+	fmt.Println("      --type=string|int|float|bool|time|json - Type of a set value, the key's schema type by default")
 	fmt.Println("  huh serve                              - Start the web API server for camera capture and gallery")
 	fmt.Println("  huh help                               - Show this help message")
 	fmt.Println("\nExamples:")
 	fmt.Println("  huh convert image.png image.huh")
+	fmt.Println("  huh convert --compression=fast photo.jpg photo.huh")
+	fmt.Println("  huh convert --quality=75 photo.jpg photo.huh")
+	fmt.Println("  huh convert --color-type=gray scan.png scan.huh")
+	fmt.Println("  huh convert --thumbnail=256 photo.jpg photo.huh")
 	fmt.Println("  huh convert image.huh image.jpg")
+	fmt.Println("  huh convert --strip-metadata photo.huh photo.png")
+	fmt.Println("  huh convert scan.tiff scan.qoi")
+	fmt.Println("  huh convert --to=png image.huh preview.img")
+	fmt.Println("  huh convert --quality=80 photo.huh photo.huh")
+	fmt.Println("  huh convert --to huh --out-dir dist/ 'photos/**/*.jpg'")
+	fmt.Println("  curl -s https://example.com/a.png | huh convert --to=huh - - > a.huh")
 	fmt.Println("  huh view image.huh")
+	fmt.Println("  huh verify uploads/*.huh")
+	fmt.Println("  huh meta set photo.huh author \"Jane Doe\"")
 	fmt.Println("  huh serve")
 }
 
 func main() {
-	imageToHuhFunc = imageToHuh
-	huhToImageFunc = huhToImage
-
 	args := os.Args
 	if len(args) < 2 {
 		printUsage()
@@ -685,85 +1780,101 @@ func main() {
 	command := args[1]
 	var err error
 
-	imageToHuhServer := func(img image.Image, metadata Metadata, huhPath string) error {
-		oldStdout := os.Stdout
-		_, w, _ := os.Pipe()
-		os.Stdout = w
-		defer func() {
-			w.Close()
-			os.Stdout = oldStdout
-		}()
-		return imageToHuh(img, metadata, huhPath)
-	}
-	huhToImageServer := func(huhPath string) (image.Image, Metadata, error) {
-		oldStdout := os.Stdout
-		_, w, _ := os.Pipe()
-		os.Stdout = w
-		defer func() {
-			w.Close()
-			os.Stdout = oldStdout
-		}()
-		return huhToImage(huhPath)
-	}
-
 	switch command {
 	case "convert":
-		if len(args) != 4 {
+		fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		compression := fs.String("compression", "best", "")
+		colorType := fs.String("color-type", "auto", "")
+		thumbnail := fs.Int("thumbnail", 0, "")
+		stripMetadata := fs.Bool("strip-metadata", false, "")
+		noAutoOrient := fs.Bool("no-auto-orient", false, "")
+		quality := fs.Int("quality", 0, "")
+		to := fs.String("to", "", "")
+		outDir := fs.String("out-dir", "", "")
+		jobs := fs.Int("jobs", runtime.NumCPU(), "")
+		force := fs.Bool("force", false, "")
+		positional, ferr := parseFlags(fs, args[2:])
+		if ferr != nil {
+			printError(ferr.Error())
+			printUsage()
+			return
+		}
+		if *outDir == "" && len(positional) != 2 || *outDir != "" && len(positional) == 0 {
 			printError("Invalid number of arguments for convert command")
 			printUsage()
 			return
 		}
-		inputPath, outputPath := args[2], args[3]
-		if _, err := os.Stat(inputPath); os.IsNotExist(err) {
-			printError(fmt.Sprintf("Input file does not exist: %s", inputPath))
+		opts, ferr := compressionOptions(*compression)
+		if ferr != nil {
+			printError(ferr.Error())
+			return
+		}
+		if opts.ColorType, ferr = colorTypeOption(*colorType); ferr != nil {
+			printError(ferr.Error())
+			return
+		}
+		if *thumbnail < 0 {
+			printError(fmt.Sprintf("invalid thumbnail size: %d", *thumbnail))
+			return
+		}
+		opts.Thumbnail = *thumbnail
+		if *quality < 0 || *quality > 100 {
+			printError(fmt.Sprintf("invalid quality: %d, use 1 to 100", *quality))
 			return
 		}
+		opts.Quality = *quality
+		var toFormat *imageFormat
+		if *to != "" {
+			if toFormat, ferr = lookupFormat(*to); ferr != nil {
+				printError(ferr.Error())
+				return
+			}
+		}
+		opts.Progress = printProgress
+		copts := &convertOptions{
+			huh:           opts,
+			stripMetadata: *stripMetadata,
+			autoOrient:    !*noAutoOrient,
+			to:            toFormat,
+		}
 
-		inputExt := strings.ToLower(filepath.Ext(inputPath))
-		outputExt := strings.ToLower(filepath.Ext(outputPath))
-
-		printInfo(fmt.Sprintf("Converting %s to %s", inputPath, outputPath))
-
-		if inputExt == ".huh" && outputExt != ".huh" {
-			var img image.Image
-			img, _, err = huhToImageFunc(inputPath)
-			if err == nil {
-				var outFile *os.File
-				outFile, err = os.Create(outputPath)
-				if err == nil {
-					defer outFile.Close()
-					switch outputExt {
-					case ".png":
-						err = png.Encode(outFile, img)
-					case ".jpg", ".jpeg":
-						err = jpeg.Encode(outFile, img, &jpeg.Options{Quality: 90})
-					case ".gif":
-						err = gif.Encode(outFile, img, &gif.Options{NumColors: 256})
-					default:
-						err = fmt.Errorf("unsupported output format: %s", outputExt)
-					}
-				}
+		if *outDir != "" {
+			if toFormat == nil {
+				printError("--out-dir needs the output format in --to")
+				return
+			}
+			if toFormat.encode == nil {
+				printError(fmt.Sprintf("%s images can be read but not written", strings.ToUpper(toFormat.name)))
+				return
+			}
+			if *jobs < 1 {
+				printError(fmt.Sprintf("invalid number of jobs: %d", *jobs))
+				return
 			}
-		} else if inputExt != ".huh" && outputExt == ".huh" {
-			var file *os.File
-			file, err = os.Open(inputPath)
-			if err == nil {
-				defer file.Close()
-				var img image.Image
-				img, _, err = image.Decode(file)
-				if err == nil {
-					metadata := Metadata{"source_file": filepath.Base(inputPath)}
-					err = imageToHuhFunc(img, metadata, outputPath)
+			for _, p := range positional {
+				if p == "-" {
+					printError("standard input cannot be converted with --out-dir")
+					return
 				}
 			}
-		} else if inputExt != ".huh" && outputExt != ".huh" {
-			err = convertImage(inputPath, outputPath)
-		} else {
-			err = errors.New("cannot convert from HUH to HUH")
+			err = convertBatch(positional, *outDir, *jobs, *force, copts)
+			break
 		}
 
+		inputPath, outputPath := positional[0], positional[1]
+		if _, err := os.Stat(inputPath); inputPath != "-" && os.IsNotExist(err) {
+			printError(fmt.Sprintf("Input file does not exist: %s", inputPath))
+			return
+		}
+		outputName := outputPath
+		if outputPath == "-" {
+			outputName = "standard output"
+		}
+		printInfo(fmt.Sprintf("Converting %s to %s", displayName(inputPath), outputName))
+		err = convertFile(inputPath, outputPath, copts)
 		if err == nil {
-			printSuccess(fmt.Sprintf("Successfully converted %s to %s", inputPath, outputPath))
+			printSuccess(fmt.Sprintf("Successfully converted %s to %s", displayName(inputPath), outputName))
 		}
 
 	case "view":
@@ -773,16 +1884,36 @@ func main() {
 			return
 		}
 		filePath := args[2]
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		if _, err := os.Stat(filePath); filePath != "-" && os.IsNotExist(err) {
 			printError(fmt.Sprintf("File does not exist: %s", filePath))
 			return
 		}
-		printInfo(fmt.Sprintf("Viewing: %s", filePath))
+		printInfo(fmt.Sprintf("Viewing: %s", displayName(filePath)))
 		err = viewImage(filePath)
 
+	case "verify":
+		if len(args) < 3 {
+			printError("No files given for verify command")
+			printUsage()
+			return
+		}
+		failed := 0
+		for _, filePath := range args[2:] {
+			if verr := verifyFile(filePath); verr != nil {
+				printError(fmt.Sprintf("%s: %v", filePath, verr))
+				failed++
+			} else {
+				printSuccess(fmt.Sprintf("%s: OK", filePath))
+			}
+		}
+		if failed > 0 {
+			err = fmt.Errorf("%d of %d files failed verification", failed, len(args)-2)
+		}
+
+	case "meta":
+		err = metaCommand(args[2:])
+
 	case "serve":
-		huhToImageFunc = huhToImageServer
-		imageToHuhFunc = imageToHuhServer
 		startServer()
 
 	case "help", "--help", "-h":
@@ -0,0 +1,343 @@
+package pnm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+
+	"huh/src/internal/imageutil"
+)
+
+// header describes a Netpbm file. PBM files have one channel with a
+// maximum value of 1, where 1 is black.
+type header struct {
+	magic         byte
+	width, height int
+	depth, maxval int
+}
+
+func (h *header) plain() bool { return h.magic >= '1' && h.magic <= '3' }
+
+func readHeader(br *bufio.Reader) (*header, error) {
+	var magic [2]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil || magic[0] != 'P' || magic[1] < '1' || magic[1] > '7' {
+		return nil, errors.New("pnm: invalid header")
+	}
+	h := &header{magic: magic[1]}
+	var err error
+	if h.magic == '7' {
+		err = h.readPAM(br)
+	} else {
+		err = h.readPNM(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if h.width <= 0 || h.height <= 0 || uint64(h.width)*uint64(h.height) > maxPixels {
+		return nil, fmt.Errorf("pnm: invalid image size %dx%d", h.width, h.height)
+	}
+	if h.maxval < 1 || h.maxval > 65535 {
+		return nil, fmt.Errorf("pnm: invalid maximum value %d", h.maxval)
+	}
+	if h.depth < 1 || h.depth > 4 {
+		return nil, fmt.Errorf("pnm: unsupported depth %d", h.depth)
+	}
+	return h, nil
+}
+
+// readPNM reads the header fields of PBM, PGM and PPM files, separated by
+// whitespace and comments, up to the single whitespace byte that precedes
+// the raster.
+func (h *header) readPNM(br *bufio.Reader) error {
+	fields := []*int{&h.width, &h.height, &h.maxval}
+	switch h.magic {
+	case '1', '4':
+		fields = fields[:2]
+		h.depth, h.maxval = 1, 1
+	case '2', '5':
+		h.depth = 1
+	default:
+		h.depth = 3
+	}
+	for _, field := range fields {
+		n, err := readNumber(br)
+		if err != nil {
+			return err
+		}
+		*field = n
+	}
+	if h.plain() {
+		return nil
+	}
+	b, err := br.ReadByte()
+	if err != nil || !isSpace(b) {
+		return errors.New("pnm: invalid header")
+	}
+	return nil
+}
+
+// readPAM reads the header lines of a PAM file, up to ENDHDR.
+func (h *header) readPAM(br *bufio.Reader) error {
+	tupleType := ""
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return errors.New("pnm: invalid PAM header")
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "ENDHDR" {
+			break
+		}
+		if fields[0] == "TUPLTYPE" {
+			tupleType = strings.Join(fields[1:], " ")
+			continue
+		}
+		var n int
+		if len(fields) == 2 {
+			n, err = strconv.Atoi(fields[1])
+		}
+		if len(fields) != 2 || err != nil {
+			return fmt.Errorf("pnm: invalid PAM header line %q", strings.TrimSpace(line))
+		}
+		switch fields[0] {
+		case "WIDTH":
+			h.width = n
+		case "HEIGHT":
+			h.height = n
+		case "DEPTH":
+			h.depth = n
+		case "MAXVAL":
+			h.maxval = n
+		}
+	}
+	// The channels follow from the depth; the tuple type only has to agree.
+	want := map[string]int{"BLACKANDWHITE": 1, "GRAYSCALE": 1, "RGB": 3,
+		"BLACKANDWHITE_ALPHA": 2, "GRAYSCALE_ALPHA": 2, "RGB_ALPHA": 4}
+	if d, ok := want[tupleType]; ok && d != h.depth {
+		return fmt.Errorf("pnm: tuple type %s with depth %d", tupleType, h.depth)
+	}
+	return nil
+}
+
+// readNumber reads a decimal number after any whitespace and comments.
+func readNumber(br *bufio.Reader) (int, error) {
+	b, err := skipSpace(br)
+	if err != nil {
+		return 0, err
+	}
+	n, digits := 0, 0
+	for b >= '0' && b <= '9' {
+		digits++
+		if n = n*10 + int(b-'0'); n > 1<<30 {
+			return 0, errors.New("pnm: number too large")
+		}
+		if b, err = br.ReadByte(); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		br.UnreadByte()
+	}
+	if digits == 0 {
+		return 0, errors.New("pnm: invalid number")
+	}
+	return n, nil
+}
+
+// skipSpace returns the first byte that is neither whitespace nor part of
+// a comment.
+func skipSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, imageutil.NoEOF(err)
+		}
+		if b == '#' {
+			if _, err := br.ReadString('\n'); err != nil {
+				return 0, imageutil.NoEOF(err)
+			}
+			continue
+		}
+		if !isSpace(b) {
+			return b, nil
+		}
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+// newImage returns an image for the pixels of h: Gray for one channel,
+// RGBA for three and NRGBA for channels with alpha, all 16-bit if the
+// maximum value needs it.
+func (h *header) newImage() image.Image {
+	r := image.Rect(0, 0, h.width, h.height)
+	wide := h.maxval > 255
+	switch {
+	case h.depth == 1 && wide:
+		return image.NewGray16(r)
+	case h.depth == 1:
+		return image.NewGray(r)
+	case h.depth == 3 && wide:
+		return image.NewRGBA64(r)
+	case h.depth == 3:
+		return image.NewRGBA(r)
+	case wide:
+		return image.NewNRGBA64(r)
+	}
+	return image.NewNRGBA(r)
+}
+
+// colorModel returns the color model of the images newImage returns.
+func (h *header) colorModel() color.Model {
+	wide := h.maxval > 255
+	switch {
+	case h.depth == 1 && wide:
+		return color.Gray16Model
+	case h.depth == 1:
+		return color.GrayModel
+	case h.depth == 3 && wide:
+		return color.RGBA64Model
+	case h.depth == 3:
+		return color.RGBAModel
+	case wide:
+		return color.NRGBA64Model
+	}
+	return color.NRGBAModel
+}
+
+// Decode reads a Netpbm image from r. PBM and 8-bit PGM files decode to an
+// *image.Gray, 8-bit PPM files to an *image.RGBA and PAM files with alpha
+// to an *image.NRGBA, or to their 16-bit counterparts if the maximum value
+// of the file is above 255. Samples are scaled to the full range of the
+// image.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	img := h.newImage()
+	row := make([]int, h.width*h.depth)
+	for y := 0; y < h.height; y++ {
+		if err := h.readRow(br, row); err != nil {
+			return nil, err
+		}
+		h.setRow(img, y, row)
+	}
+	return img, nil
+}
+
+// readRow reads the samples of one row, scaled to 0..65535.
+func (h *header) readRow(br *bufio.Reader, row []int) error {
+	switch {
+	case h.magic == '4':
+		packed := make([]byte, (h.width+7)/8)
+		if _, err := io.ReadFull(br, packed); err != nil {
+			return imageutil.NoEOF(err)
+		}
+		for x := range row {
+			row[x] = int(packed[x/8]>>(7-x%8)) & 1
+		}
+	case h.magic == '1':
+		// Plain PBM samples need not be separated.
+		for x := range row {
+			b, err := skipSpace(br)
+			if err != nil {
+				return err
+			}
+			if b != '0' && b != '1' {
+				return errors.New("pnm: invalid PBM sample")
+			}
+			row[x] = int(b - '0')
+		}
+	case h.plain():
+		for x := range row {
+			n, err := readNumber(br)
+			if err != nil {
+				return err
+			}
+			row[x] = n
+		}
+	default:
+		size := 1
+		if h.maxval > 255 {
+			size = 2
+		}
+		buf := make([]byte, len(row)*size)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return imageutil.NoEOF(err)
+		}
+		for x := range row {
+			if size == 1 {
+				row[x] = int(buf[x])
+			} else {
+				row[x] = int(buf[2*x])<<8 | int(buf[2*x+1])
+			}
+		}
+	}
+	for x, v := range row {
+		if v > h.maxval {
+			return fmt.Errorf("pnm: sample %d exceeds the maximum value %d", v, h.maxval)
+		}
+		if h.magic == '1' || h.magic == '4' {
+			v = 1 - v
+		}
+		row[x] = (v*65535 + h.maxval/2) / h.maxval
+	}
+	return nil
+}
+
+// setRow stores row y of samples scaled to 0..65535 in img, which was
+// returned by newImage.
+func (h *header) setRow(img image.Image, y int, row []int) {
+	for x := 0; x < h.width; x++ {
+		px := row[x*h.depth : (x+1)*h.depth]
+		var c color.NRGBA64
+		switch h.depth {
+		case 1:
+			c = color.NRGBA64{uint16(px[0]), uint16(px[0]), uint16(px[0]), 0xffff}
+		case 2:
+			c = color.NRGBA64{uint16(px[0]), uint16(px[0]), uint16(px[0]), uint16(px[1])}
+		case 3:
+			c = color.NRGBA64{uint16(px[0]), uint16(px[1]), uint16(px[2]), 0xffff}
+		default:
+			c = color.NRGBA64{uint16(px[0]), uint16(px[1]), uint16(px[2]), uint16(px[3])}
+		}
+		// Alpha is set as it is; going through premultiplied colors would
+		// lose the color of nearly transparent pixels.
+		switch img := img.(type) {
+		case *image.Gray:
+			img.SetGray(x, y, color.Gray{uint8(c.R >> 8)})
+		case *image.Gray16:
+			img.SetGray16(x, y, color.Gray16{c.R})
+		case *image.RGBA:
+			img.SetRGBA(x, y, color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), 0xff})
+		case *image.RGBA64:
+			img.SetRGBA64(x, y, color.RGBA64{c.R, c.G, c.B, 0xffff})
+		case *image.NRGBA:
+			img.SetNRGBA(x, y, color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)})
+		case *image.NRGBA64:
+			img.SetNRGBA64(x, y, c)
+		}
+	}
+}
+
+// DecodeConfig returns the color model and dimensions of a Netpbm image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: h.colorModel(), Width: h.width, Height: h.height}, nil
+}
@@ -0,0 +1,117 @@
+package pnm
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"huh/src/internal/imageutil"
+)
+
+// Encode writes img to w in the raw form of the format chosen by o, with
+// a maximum value of 65535 for 16-bit images and 255 otherwise. A nil o
+// selects Auto.
+func Encode(w io.Writer, img image.Image, o *Options) error {
+	format := Auto
+	if o != nil {
+		format = o.Format
+	}
+	gray, alpha := isGray(img), !imageutil.Opaque(img)
+	if format == Auto {
+		switch {
+		case alpha:
+			format = PAM
+		case gray:
+			format = PGM
+		default:
+			format = PPM
+		}
+	}
+	depth := 3
+	switch format {
+	case PGM:
+		depth = 1
+	case PAM:
+		if gray {
+			depth = 1
+		}
+		if alpha {
+			depth++
+		}
+	case PPM:
+	default:
+		return fmt.Errorf("pnm: unknown format %d", format)
+	}
+
+	maxval, size := 255, 1
+	if is16Bit(img) {
+		maxval, size = 65535, 2
+	}
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	switch format {
+	case PPM:
+		fmt.Fprintf(bw, "P6\n%d %d\n%d\n", b.Dx(), b.Dy(), maxval)
+	case PGM:
+		fmt.Fprintf(bw, "P5\n%d %d\n%d\n", b.Dx(), b.Dy(), maxval)
+	case PAM:
+		tupleType := map[int]string{1: "GRAYSCALE", 2: "GRAYSCALE_ALPHA", 3: "RGB", 4: "RGB_ALPHA"}[depth]
+		fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH %d\nMAXVAL %d\nTUPLTYPE %s\nENDHDR\n",
+			b.Dx(), b.Dy(), depth, maxval, tupleType)
+	}
+
+	row := make([]byte, 0, b.Dx()*depth*size)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row = row[:0]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			n := nrgba64At(img, x, y)
+			var samples [4]uint16
+			switch depth {
+			case 1, 2:
+				samples[0] = color.Gray16Model.Convert(color.NRGBA64{n.R, n.G, n.B, 0xffff}).(color.Gray16).Y
+				samples[1] = n.A
+			default:
+				samples = [4]uint16{n.R, n.G, n.B, n.A}
+			}
+			for _, s := range samples[:depth] {
+				if size == 1 {
+					row = append(row, byte(s>>8))
+				} else {
+					row = append(row, byte(s>>8), byte(s))
+				}
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// nrgba64At returns the pixel of img at x, y with straight alpha, exactly
+// for images that store it that way.
+func nrgba64At(img image.Image, x, y int) color.NRGBA64 {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		c := img.NRGBAAt(x, y)
+		return color.NRGBA64{uint16(c.R) * 0x101, uint16(c.G) * 0x101, uint16(c.B) * 0x101, uint16(c.A) * 0x101}
+	case *image.NRGBA64:
+		return img.NRGBA64At(x, y)
+	}
+	return color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+}
+
+func isGray(img image.Image) bool {
+	m := img.ColorModel()
+	return m == color.GrayModel || m == color.Gray16Model
+}
+
+func is16Bit(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model, color.Alpha16Model:
+		return true
+	}
+	return false
+}
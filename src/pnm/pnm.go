@@ -0,0 +1,46 @@
+// Package pnm implements the Netpbm image formats: PBM bitmaps, PGM gray
+// maps and PPM pixel maps, in their plain text and raw binary forms, and
+// PAM, which adds alpha. Samples take one byte, or two big-endian bytes if
+// the maximum value of the file is above 255. Importing this package
+// registers the formats with the standard library, so image.Decode
+// understands Netpbm files.
+package pnm
+
+import "image"
+
+// Format is one of the formats Encode writes.
+type Format int
+
+const (
+	// Auto writes PGM for gray images, PPM for opaque images and PAM for
+	// images with transparency.
+	Auto Format = iota
+	// PPM writes raw pixel maps, dropping alpha.
+	PPM
+	// PGM writes raw gray maps.
+	PGM
+	// PAM writes arbitrary maps with a gray or RGB tuple type, and alpha
+	// if the image is not opaque.
+	PAM
+)
+
+// Options are the encoding parameters.
+type Options struct {
+	Format Format
+}
+
+// maxPixels bounds width×height. Netpbm sizes are decimal text, so a few
+// bytes of header can claim any size, and Decode allocates the image
+// before it reads the first sample.
+const maxPixels = 1 << 28
+
+func init() {
+	for _, f := range []struct{ name, magic string }{
+		{"pbm", "P1"}, {"pbm", "P4"},
+		{"pgm", "P2"}, {"pgm", "P5"},
+		{"ppm", "P3"}, {"ppm", "P6"},
+		{"pam", "P7"},
+	} {
+		image.RegisterFormat(f.name, f.magic, Decode, DecodeConfig)
+	}
+}
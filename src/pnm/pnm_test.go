@@ -0,0 +1,203 @@
+package pnm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	r := image.Rect(0, 0, 11, 6)
+	rng := rand.New(rand.NewSource(1))
+	gray := image.NewGray(r)
+	gray16 := image.NewGray16(r)
+	rgba := image.NewRGBA(r)
+	rgba64 := image.NewRGBA64(r)
+	nrgba := image.NewNRGBA(r)
+	nrgba64 := image.NewNRGBA64(r)
+	for _, pix := range [][]byte{gray.Pix, gray16.Pix, rgba.Pix, rgba64.Pix, nrgba.Pix, nrgba64.Pix} {
+		rng.Read(pix)
+	}
+	// PPM has no alpha, so the RGBA images are made opaque.
+	for _, img := range []interface{ Set(int, int, color.Color) }{rgba, rgba64} {
+		for y := 0; y < 6; y++ {
+			for x := 0; x < 11; x++ {
+				r, g, b, _ := img.(image.Image).At(x, y).RGBA()
+				img.Set(x, y, color.RGBA64{uint16(r), uint16(g), uint16(b), 0xffff})
+			}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		format Format
+		magic  string
+		want   image.Image
+	}{
+		{"Gray auto", gray, Auto, "P5", gray},
+		{"Gray16 auto", gray16, Auto, "P5", gray16},
+		{"RGBA auto", rgba, Auto, "P6", rgba},
+		{"RGBA64 auto", rgba64, Auto, "P6", rgba64},
+		{"NRGBA auto", nrgba, Auto, "P7", nrgba},
+		{"NRGBA64 auto", nrgba64, Auto, "P7", nrgba64},
+		{"Gray PAM", gray, PAM, "P7", gray},
+		{"RGBA PAM", rgba, PAM, "P7", rgba},
+		{"Gray PPM", gray, PPM, "P6", nil},
+		{"subimage", nrgba.SubImage(image.Rect(2, 1, 9, 5)), Auto, "P7", nrgba.SubImage(image.Rect(2, 1, 9, 5))},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tt.img, &Options{Format: tt.format}); err != nil {
+			t.Fatalf("%s: Encode: %v", tt.name, err)
+		}
+		if !bytes.HasPrefix(buf.Bytes(), []byte(tt.magic)) {
+			t.Errorf("%s: starts with %q, want %s", tt.name, buf.Bytes()[:2], tt.magic)
+		}
+		b := tt.img.Bounds()
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+		if err != nil || cfg.Width != b.Dx() || cfg.Height != b.Dy() {
+			t.Errorf("%s: DecodeConfig: %v, %+v", tt.name, err, cfg)
+		}
+		got, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", tt.name, err)
+		}
+		if cfg.ColorModel != got.ColorModel() {
+			t.Errorf("%s: DecodeConfig gives %v, Decode %v", tt.name, cfg.ColorModel, got.ColorModel())
+		}
+		if got.Bounds() != b.Sub(b.Min) {
+			t.Fatalf("%s: bounds %v, want %v", tt.name, got.Bounds(), b.Sub(b.Min))
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				g := nrgba64At(got, x-b.Min.X, y-b.Min.Y)
+				var want color.NRGBA64
+				if tt.want != nil {
+					want = nrgba64At(tt.want, x, y)
+				} else {
+					want = color.NRGBA64Model.Convert(tt.img.At(x, y)).(color.NRGBA64)
+				}
+				if g != want {
+					t.Fatalf("%s: pixel (%d, %d) is %v, want %v", tt.name, x, y, g, want)
+				}
+			}
+		}
+	}
+
+	// Gray images keep a single channel in PAM.
+	var buf bytes.Buffer
+	if err := Encode(&buf, image.NewGray(r), &Options{Format: PAM}); err != nil || !strings.Contains(buf.String(), "TUPLTYPE GRAYSCALE\n") {
+		t.Errorf("gray PAM: %v\n%s", err, buf.Bytes()[:40])
+	}
+	if err := Encode(io.Discard, gray, &Options{Format: 99}); err == nil {
+		t.Error("unknown format: no error")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want image.Image
+	}{
+		{"plain PBM", "P1\n# a comment\n3 2\n1 0 1\n010", &image.Gray{
+			Pix: []uint8{0, 255, 0, 255, 0, 255}, Stride: 3, Rect: image.Rect(0, 0, 3, 2)}},
+		{"raw PBM", "P4 10 2\n\xc0\x40\x00\x80", &image.Gray{
+			Pix: []uint8{0, 0, 255, 255, 255, 255, 255, 255, 255, 0,
+				255, 255, 255, 255, 255, 255, 255, 255, 0, 255},
+			Stride: 10, Rect: image.Rect(0, 0, 10, 2)}},
+		{"plain PGM", "P2 2 2 15\n0 15\n# mid\n5 10\n", &image.Gray{
+			Pix: []uint8{0, 255, 85, 170}, Stride: 2, Rect: image.Rect(0, 0, 2, 2)}},
+		{"raw PGM", "P5\n2 1\n255\n\x00\x7f", &image.Gray{
+			Pix: []uint8{0, 127}, Stride: 2, Rect: image.Rect(0, 0, 2, 1)}},
+		{"16-bit PGM", "P5 1 1 65535\n\x12\x34", &image.Gray16{
+			Pix: []uint8{0x12, 0x34}, Stride: 2, Rect: image.Rect(0, 0, 1, 1)}},
+		{"plain PPM", "P3\n1 2\n#c\n255\n1 2 3\n4 5 6", &image.RGBA{
+			Pix: []uint8{1, 2, 3, 255, 4, 5, 6, 255}, Stride: 4, Rect: image.Rect(0, 0, 1, 2)}},
+		{"raw PPM", "P6 1 1 255\r\x01\x02\x03", &image.RGBA{
+			Pix: []uint8{1, 2, 3, 255}, Stride: 4, Rect: image.Rect(0, 0, 1, 1)}},
+		{"PAM gray alpha", "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 2\nMAXVAL 255\nTUPLTYPE GRAYSCALE_ALPHA\nENDHDR\n\x10\x00\x20\xff", &image.NRGBA{
+			Pix: []uint8{0x10, 0x10, 0x10, 0, 0x20, 0x20, 0x20, 0xff}, Stride: 8, Rect: image.Rect(0, 0, 2, 1)}},
+		{"PAM without tuple type", "P7\n# c\nWIDTH 1\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nENDHDR\n\x01\x02\x03\x04", &image.NRGBA{
+			Pix: []uint8{1, 2, 3, 4}, Stride: 4, Rect: image.Rect(0, 0, 1, 1)}},
+	}
+	for _, tt := range tests {
+		got, err := Decode(strings.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"empty", "", "invalid header"},
+		{"bad magic", "P8 1 1 255\n\x00", "invalid header"},
+		{"not netpbm", "GIF89a", "invalid header"},
+		{"zero width", "P5 0 1 255\n", "invalid image size"},
+		{"zero height", "P5 1 0 255\n", "invalid image size"},
+		{"too many pixels", "P5 100000 100000 255\n", "invalid image size"},
+		{"number too large", "P5 99999999999 1 255\n", "number too large"},
+		{"missing number", "P5 1 x 255\n", "invalid number"},
+		{"zero maxval", "P5 1 1 0\n\x00", "invalid maximum value"},
+		{"maxval too large", "P5 1 1 65536\n\x00\x00", "invalid maximum value"},
+		{"no space before raster", "P5 1 1 255", "invalid header"},
+		{"sample above maxval", "P5 1 1 15\n\x10", "exceeds the maximum value"},
+		{"plain sample above maxval", "P2 1 1 15 16", "exceeds the maximum value"},
+		{"plain PBM sample", "P1 1 1 2", "invalid PBM sample"},
+		{"truncated raster", "P6 2 2 255\n\x00\x00\x00", io.ErrUnexpectedEOF.Error()},
+		{"truncated plain raster", "P3 1 1 255 1 2", io.ErrUnexpectedEOF.Error()},
+		{"PAM depth", "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 5\nMAXVAL 255\nENDHDR\n", "unsupported depth"},
+		{"PAM tuple type", "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 3\nMAXVAL 255\nTUPLTYPE GRAYSCALE\nENDHDR\n", "tuple type"},
+		{"PAM header line", "P7\nWIDTH one\nENDHDR\n", "invalid PAM header line"},
+		{"PAM without ENDHDR", "P7\nWIDTH 1\n", "invalid PAM header"},
+		{"PAM without width", "P7\nHEIGHT 1\nDEPTH 1\nMAXVAL 255\nENDHDR\n", "invalid image size"},
+	}
+	for _, tt := range tests {
+		_, err := Decode(strings.NewReader(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Every truncation of a valid file fails, and damage never panics.
+	var buf bytes.Buffer
+	img := image.NewNRGBA64(image.Rect(0, 0, 9, 5))
+	rand.New(rand.NewSource(2)).Read(img.Pix)
+	Encode(&buf, img, nil)
+	files := [][]byte{buf.Bytes(), []byte("P3\n# c\n2 2\n255\n1 2 3 4 5 6\n7 8 9 10 11 12\n"), []byte("P1 4 2 0101 1100")}
+	rng := rand.New(rand.NewSource(3))
+	for _, valid := range files {
+		if _, err := Decode(bytes.NewReader(valid)); err != nil {
+			t.Fatalf("%q: %v", valid[:2], err)
+		}
+		for n := range len(valid) {
+			// Plain files may end within their last number.
+			if valid[1] <= '3' && n >= len(valid)-2 {
+				continue
+			}
+			if _, err := Decode(bytes.NewReader(valid[:n])); err == nil {
+				t.Fatalf("%q truncated to %d of %d bytes: no error", valid[:2], n, len(valid))
+			}
+		}
+		for range 2000 {
+			damaged := bytes.Clone(valid)
+			damaged[rng.Intn(len(damaged))] = byte(rng.Intn(256))
+			Decode(bytes.NewReader(damaged))
+		}
+	}
+}
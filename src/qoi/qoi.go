@@ -0,0 +1,230 @@
+// Package qoi implements the QOI image format, the "Quite OK Image
+// Format" of https://qoiformat.org: lossless RGB and RGBA images coded
+// with runs, a 64-entry cache of recent colors and small differences to
+// the previous pixel. Importing this package registers the format with
+// the standard library, so image.Decode understands QOI files.
+package qoi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"huh/src/internal/imageutil"
+)
+
+// Magic is the signature every QOI file starts with.
+const Magic = "qoif"
+
+const (
+	opIndex = 0x00 // 00xxxxxx: color cache index
+	opDiff  = 0x40 // 01drdgdb: small difference, 2 bits per channel
+	opLuma  = 0x80 // 10dgdgdg drdrdbdb: difference led by green
+	opRun   = 0xc0 // 11rrrrrr: run of 1 to 62 pixels
+	opRGB   = 0xfe
+	opRGBA  = 0xff
+	maskOp  = 0xc0
+)
+
+// padding ends every QOI file.
+var padding = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+// maxPixels is the limit the QOI reference decoder puts on width×height.
+// A single run byte covers up to 62 pixels, so even a short file can hold
+// a huge image, and the header is all Decode has to go by when it
+// allocates one.
+const maxPixels = 400_000_000
+
+type header struct {
+	width, height uint32
+	channels      uint8
+	colorspace    uint8
+}
+
+func readHeader(r io.Reader) (header, error) {
+	var buf [14]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil || string(buf[:4]) != Magic {
+		return header{}, errors.New("qoi: invalid header")
+	}
+	h := header{
+		width:      binary.BigEndian.Uint32(buf[4:]),
+		height:     binary.BigEndian.Uint32(buf[8:]),
+		channels:   buf[12],
+		colorspace: buf[13],
+	}
+	if h.channels != 3 && h.channels != 4 {
+		return h, fmt.Errorf("qoi: invalid channel count %d", h.channels)
+	}
+	if h.width == 0 || h.height == 0 || uint64(h.width)*uint64(h.height) > maxPixels {
+		return h, fmt.Errorf("qoi: invalid image size %dx%d", h.width, h.height)
+	}
+	return h, nil
+}
+
+func hash(c color.NRGBA) int {
+	return (int(c.R)*3 + int(c.G)*5 + int(c.B)*7 + int(c.A)*11) % 64
+}
+
+// Decode reads a QOI image from r. Files with three channels decode to an
+// opaque *image.NRGBA, like files with four.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, int(h.width), int(h.height)))
+	var cache [64]color.NRGBA
+	px := color.NRGBA{A: 255}
+	run := 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		if run > 0 {
+			run--
+		} else {
+			b, err := br.ReadByte()
+			if err != nil {
+				return nil, imageutil.NoEOF(err)
+			}
+			switch {
+			case b == opRGB, b == opRGBA:
+				n := 3
+				if b == opRGBA {
+					n = 4
+				}
+				var c [4]byte
+				if _, err := io.ReadFull(br, c[:n]); err != nil {
+					return nil, imageutil.NoEOF(err)
+				}
+				px.R, px.G, px.B = c[0], c[1], c[2]
+				if b == opRGBA {
+					px.A = c[3]
+				}
+			case b&maskOp == opIndex:
+				px = cache[b]
+			case b&maskOp == opDiff:
+				px.R += (b>>4)&3 - 2
+				px.G += (b>>2)&3 - 2
+				px.B += b&3 - 2
+			case b&maskOp == opLuma:
+				b2, err := br.ReadByte()
+				if err != nil {
+					return nil, imageutil.NoEOF(err)
+				}
+				dg := b&0x3f - 32
+				px.R += dg + b2>>4 - 8
+				px.G += dg
+				px.B += dg + b2&0x0f - 8
+			default:
+				run = int(b & 0x3f)
+			}
+			cache[hash(px)] = px
+		}
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = px.R, px.G, px.B, px.A
+	}
+	var end [8]byte
+	if _, err := io.ReadFull(br, end[:]); err != nil || string(end[:]) != string(padding) {
+		return nil, errors.New("qoi: missing end marker")
+	}
+	return img, nil
+}
+
+// DecodeConfig returns the color model and dimensions of a QOI image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: int(h.width), Height: int(h.height)}, nil
+}
+
+// Encode writes img to w in QOI format, with three channels if img is
+// opaque and four otherwise. Empty images have no QOI encoding.
+func Encode(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if b.Empty() || uint64(b.Dx())*uint64(b.Dy()) > maxPixels {
+		return fmt.Errorf("qoi: invalid image size %dx%d", b.Dx(), b.Dy())
+	}
+	channels := byte(3)
+	if !imageutil.Opaque(img) {
+		channels = 4
+	}
+	bw := bufio.NewWriter(w)
+	var head [14]byte
+	copy(head[:], Magic)
+	binary.BigEndian.PutUint32(head[4:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(head[8:], uint32(b.Dy()))
+	head[12] = channels
+	bw.Write(head[:])
+
+	var cache [64]color.NRGBA
+	prev := color.NRGBA{A: 255}
+	run := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			px := nrgbaAt(img, x, y)
+			if px == prev {
+				run++
+				if run == 62 {
+					bw.WriteByte(opRun | byte(run-1))
+					run = 0
+				}
+				continue
+			}
+			if run > 0 {
+				bw.WriteByte(opRun | byte(run-1))
+				run = 0
+			}
+			i := hash(px)
+			if cache[i] == px {
+				bw.WriteByte(opIndex | byte(i))
+				prev = px
+				continue
+			}
+			cache[i] = px
+			if px.A != prev.A {
+				bw.Write([]byte{opRGBA, px.R, px.G, px.B, px.A})
+				prev = px
+				continue
+			}
+			// Differences wrap around, as the decoder adds them modulo 256.
+			dr, dg, db := int8(px.R-prev.R), int8(px.G-prev.G), int8(px.B-prev.B)
+			dgr, dgb := dr-dg, db-dg
+			switch {
+			case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+				bw.WriteByte(opDiff | byte(dr+2)<<4 | byte(dg+2)<<2 | byte(db+2))
+			case dg >= -32 && dg <= 31 && dgr >= -8 && dgr <= 7 && dgb >= -8 && dgb <= 7:
+				bw.Write([]byte{opLuma | byte(dg+32), byte(dgr+8)<<4 | byte(dgb+8)})
+			default:
+				bw.Write([]byte{opRGB, px.R, px.G, px.B})
+			}
+			prev = px
+		}
+	}
+	if run > 0 {
+		bw.WriteByte(opRun | byte(run-1))
+	}
+	bw.Write(padding)
+	return bw.Flush()
+}
+
+// nrgbaAt returns the pixel of img at x, y with straight alpha, exactly
+// for images that store it that way.
+func nrgbaAt(img image.Image, x, y int) color.NRGBA {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		return img.NRGBAAt(x, y)
+	case *image.NRGBA64:
+		c := img.NRGBA64At(x, y)
+		return color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+	}
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
+
+func init() {
+	image.RegisterFormat("qoi", Magic, Decode, DecodeConfig)
+}
@@ -0,0 +1,194 @@
+package qoi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func rawHeader(w, h uint32, channels byte) []byte {
+	head := []byte(Magic)
+	head = binary.BigEndian.AppendUint32(head, w)
+	head = binary.BigEndian.AppendUint32(head, h)
+	return append(head, channels, 0)
+}
+
+// testImage has runs longer than 62 pixels, repeated colors for the cache,
+// small and large differences, differences that wrap around, and alpha
+// changes if alpha is set.
+func testImage(alpha bool) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 150, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 150; x++ {
+			var c color.NRGBA
+			switch {
+			case y < 5:
+				c = color.NRGBA{10, 20, 30, 255}
+			case y < 10:
+				c = color.NRGBA{uint8(x), uint8(x + 1), uint8(x - 1), 255}
+			case y < 15:
+				c = color.NRGBA{uint8(x * 20), uint8(x * 9), uint8(x * 25), 255}
+			case y < 20:
+				// 255 and 0 alternate, a difference of 1 modulo 256.
+				v := uint8(255 * (x % 2))
+				c = color.NRGBA{v, v, v, 255}
+			case y < 25:
+				palette := []color.NRGBA{{200, 0, 0, 255}, {0, 200, 0, 255}, {0, 0, 200, 255}}
+				c = palette[rng.Intn(len(palette))]
+			default:
+				c = color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+			}
+			if alpha && y%3 == 0 {
+				c.A = uint8(x)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestRoundTrip(t *testing.T) {
+	opaque := testImage(false)
+	alpha := testImage(true)
+	gray := image.NewGray(image.Rect(0, 0, 70, 3))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i / 3)
+	}
+	n64 := image.NewNRGBA64(image.Rect(0, 0, 4, 4))
+	for i := range n64.Pix {
+		n64.Pix[i] = uint8(i * 37)
+	}
+	tests := []struct {
+		name     string
+		img      image.Image
+		channels byte
+	}{
+		{"opaque", opaque, 3},
+		{"alpha", alpha, 4},
+		{"subimage", alpha.SubImage(image.Rect(17, 3, 90, 33)), 4},
+		{"opaque subimage", alpha.SubImage(image.Rect(0, 1, 150, 3)), 3},
+		{"gray", gray, 3},
+		{"NRGBA64", n64, 4},
+		{"single pixel", opaque.SubImage(image.Rect(0, 0, 1, 1)), 3},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tt.img); err != nil {
+			t.Fatalf("%s: Encode: %v", tt.name, err)
+		}
+		data := buf.Bytes()
+		if data[12] != tt.channels {
+			t.Errorf("%s: %d channels, want %d", tt.name, data[12], tt.channels)
+		}
+		if !bytes.HasSuffix(data, padding) {
+			t.Errorf("%s: no end marker", tt.name)
+		}
+		b := tt.img.Bounds()
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || format != "qoi" || cfg.Width != b.Dx() || cfg.Height != b.Dy() {
+			t.Errorf("%s: DecodeConfig: %v, %q, %+v", tt.name, err, format, cfg)
+		}
+		got, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", tt.name, err)
+		}
+		if got.Bounds() != b.Sub(b.Min) {
+			t.Fatalf("%s: bounds %v, want %v", tt.name, got.Bounds(), b.Sub(b.Min))
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if c, want := got.(*image.NRGBA).NRGBAAt(x-b.Min.X, y-b.Min.Y), nrgbaAt(tt.img, x, y); c != want {
+					t.Fatalf("%s: pixel (%d, %d) is %v, want %v", tt.name, x, y, c, want)
+				}
+			}
+		}
+	}
+
+	// Runs code flat areas in a byte per 62 pixels, and small differences
+	// take a byte per pixel.
+	var buf bytes.Buffer
+	Encode(&buf, opaque.SubImage(image.Rect(0, 0, 150, 5)))
+	if limit := 14 + 4 + (5*150+61)/62 + 8; buf.Len() > limit {
+		t.Errorf("flat rows took %d bytes, want at most %d", buf.Len(), limit)
+	}
+	buf.Reset()
+	Encode(&buf, opaque.SubImage(image.Rect(0, 5, 150, 10)))
+	if limit := 14 + 5*(4+149) + 8; buf.Len() > limit {
+		t.Errorf("smooth rows took %d bytes, want at most %d", buf.Len(), limit)
+	}
+}
+
+// TestSpec decodes a stream written by hand from the specification.
+func TestSpec(t *testing.T) {
+	data := rawHeader(7, 1, 4)
+	data = append(data,
+		opRGB, 10, 20, 30, // (10, 20, 30, 255)
+		opDiff|3<<4|2<<2|1,            // +1, 0, -1: (11, 20, 29, 255)
+		opLuma|(32+4), (8+2)<<4|(8-3), // dg 4, dr-dg 2, db-dg -3: (17, 24, 30, 255)
+		opRun|1, // two more
+		opRGBA, 1, 2, 3, 4,
+		opIndex|byte(hash(color.NRGBA{10, 20, 30, 255})),
+	)
+	data = append(data, padding...)
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []color.NRGBA{{10, 20, 30, 255}, {11, 20, 29, 255}, {17, 24, 30, 255}, {17, 24, 30, 255}, {17, 24, 30, 255}, {1, 2, 3, 4}, {10, 20, 30, 255}}
+	for x, w := range want {
+		if c := img.(*image.NRGBA).NRGBAAt(x, 0); c != w {
+			t.Errorf("pixel %d is %v, want %v", x, c, w)
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	Encode(&buf, testImage(true))
+	valid := buf.Bytes()
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "invalid header"},
+		{"bad magic", append([]byte("qoix"), valid[4:]...), "invalid header"},
+		{"zero width", rawHeader(0, 10, 4), "invalid image size"},
+		{"zero height", rawHeader(10, 0, 4), "invalid image size"},
+		{"too many pixels", rawHeader(1<<20, 1<<20, 4), "invalid image size"},
+		{"channels", rawHeader(1, 1, 5), "invalid channel count"},
+		{"no pixels", rawHeader(1, 1, 4), io.ErrUnexpectedEOF.Error()},
+		{"truncated", valid[:len(valid)/2], io.ErrUnexpectedEOF.Error()},
+		{"missing end marker", valid[:len(valid)-1], "missing end marker"},
+		{"bad end marker", append(bytes.Clone(valid[:len(valid)-1]), 2), "missing end marker"},
+	}
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	for n := range len(valid) {
+		if _, err := Decode(bytes.NewReader(valid[:n])); err == nil {
+			t.Fatalf("truncated to %d of %d bytes: no error", n, len(valid))
+		}
+	}
+	// Damage to the pixel stream must never panic.
+	rng := rand.New(rand.NewSource(2))
+	for range 2000 {
+		damaged := bytes.Clone(valid)
+		damaged[14+rng.Intn(len(valid)-14)] = byte(rng.Intn(256))
+		Decode(bytes.NewReader(damaged))
+	}
+
+	if err := Encode(io.Discard, image.NewNRGBA(image.Rect(0, 0, 0, 3))); err == nil {
+		t.Error("Encode of an empty image: no error")
+	}
+}